@@ -0,0 +1,456 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/pubsub"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/pkg/errors"
+)
+
+const (
+	// connectTimeout is the timeout for connecting peers
+	connectTimeout = 30 * time.Second
+	// maxBodySize is the max size of request bodies (e.g. published messages)
+	maxBodySize = 1 << 20
+	// defaultLoggers is the regex of the loggers that are changed when no logger was specified
+	defaultLoggers = "p2p:.*"
+
+	contentTypeJSON   = "application/json"
+	contentTypeBinary = "application/octet-stream"
+)
+
+// Identity is the identity of the node
+type Identity struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// PeerInfo is the info of a connected peer
+type PeerInfo struct {
+	ID            string   `json:"id"`
+	Connectedness string   `json:"connectedness"`
+	Direction     string   `json:"direction,omitempty"`
+	Agent         string   `json:"agent,omitempty"`
+	Addrs         []string `json:"addrs"`
+}
+
+// TopicInfo is the info of a joined topic
+type TopicInfo struct {
+	Name         string                    `json:"name"`
+	Peers        []string                  `json:"peers"`
+	MeshPeers    []string                  `json:"meshPeers"`
+	Subscription *pubsub.SubscriptionStats `json:"subscription,omitempty"`
+}
+
+// Message is a pubsub message that is streamed to clients
+type Message struct {
+	Topic string `json:"topic"`
+	From  string `json:"from"`
+	Data  []byte `json:"data"`
+}
+
+// ConnectRequest is the body of a connect request
+type ConnectRequest struct {
+	// Addr is a multiaddr of the peer, including /p2p/
+	Addr string `json:"addr"`
+}
+
+// DisconnectRequest is the body of a disconnect request
+type DisconnectRequest struct {
+	ID string `json:"id"`
+}
+
+// LogLevelRequest is the body of a log level request
+type LogLevelRequest struct {
+	// Logger is the name of the logger, all the facade loggers are changed if empty
+	Logger string `json:"logger,omitempty"`
+	Level  string `json:"level"`
+}
+
+// Handler returns the http handler of the admin API.
+// Requests with bodies must have the expected content type, and requests of browsers on behalf of other sites
+// are rejected (see guard)
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity", method(http.MethodGet, s.identity))
+	mux.HandleFunc("/peers", method(http.MethodGet, s.peers))
+	mux.HandleFunc("/peers/connect", method(http.MethodPost, contentType(contentTypeJSON, s.connect)))
+	mux.HandleFunc("/peers/disconnect", method(http.MethodPost, contentType(contentTypeJSON, s.disconnect)))
+	mux.HandleFunc("/topics", method(http.MethodGet, s.topics))
+	mux.HandleFunc("/topics/", s.topic)
+	mux.HandleFunc("/protocols", method(http.MethodGet, s.protocols))
+	mux.HandleFunc("/log-level", method(http.MethodPut, contentType(contentTypeJSON, s.logLevel)))
+	return s.guard(mux)
+}
+
+// guard rejects requests with a Host (DNS rebinding) or an Origin (cross-site requests) that is not a loopback
+// address. Requests on a unix socket are not checked as browsers can't reach it
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(s.addr, unixPrefix) {
+			if !isLoopbackHost(r.Host) {
+				writeError(w, http.StatusForbidden, errors.Errorf("host is not allowed: %s", r.Host))
+				return
+			}
+			if origin := r.Header.Get("Origin"); len(origin) > 0 {
+				u, err := url.Parse(origin)
+				if err != nil || !isLoopbackHost(u.Host) {
+					writeError(w, http.StatusForbidden, errors.Errorf("origin is not allowed: %s", origin))
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackHost returns whether the given host (with an optional port) is localhost
+func isLoopbackHost(hostport string) bool {
+	h, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		h = strings.Trim(hostport, "[]")
+	}
+	return isLoopback(h)
+}
+
+// identity returns the identity and addresses of the node
+func (s *Server) identity(w http.ResponseWriter, r *http.Request) {
+	h := s.node.Host()
+	writeJSON(w, http.StatusOK, Identity{
+		ID:    h.ID().String(),
+		Addrs: addrStrings(h.Addrs()),
+	})
+}
+
+// peers returns the connected peers
+func (s *Server) peers(w http.ResponseWriter, r *http.Request) {
+	h := s.node.Host()
+	peers := make([]PeerInfo, 0)
+	for _, pid := range h.Network().Peers() {
+		info := PeerInfo{
+			ID:            pid.String(),
+			Connectedness: h.Network().Connectedness(pid).String(),
+			Addrs:         addrStrings(h.Peerstore().Addrs(pid)),
+		}
+		if conns := h.Network().ConnsToPeer(pid); len(conns) > 0 {
+			info.Direction = conns[0].Stat().Direction.String()
+		}
+		if agent, err := h.Peerstore().Get(pid, "AgentVersion"); err == nil {
+			info.Agent, _ = agent.(string)
+		}
+		peers = append(peers, info)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+	writeJSON(w, http.StatusOK, peers)
+}
+
+// connect connects to the given peer
+func (s *Server) connect(w http.ResponseWriter, r *http.Request) {
+	var req ConnectRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	pi, err := peer.AddrInfoFromString(req.Addr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid peer address"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), connectTimeout)
+	defer cancel()
+	if err := s.node.Host().Connect(ctx, *pi); err != nil {
+		writeError(w, http.StatusBadGateway, errors.Wrap(err, "could not connect"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// disconnect closes the connections to the given peer
+func (s *Server) disconnect(w http.ResponseWriter, r *http.Request) {
+	var req DisconnectRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	pid, err := peer.Decode(req.ID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid peer id"))
+		return
+	}
+	if err := s.node.Host().Network().ClosePeer(pid); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not disconnect"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// topics returns the joined topics
+func (s *Server) topics(w http.ResponseWriter, r *http.Request) {
+	names := s.node.Topics()
+	sort.Strings(names)
+	topics := make([]TopicInfo, 0, len(names))
+	for _, name := range names {
+		info := TopicInfo{
+			Name:      name,
+			Peers:     []string{},
+			MeshPeers: peerStrings(s.node.MeshPeers(name)),
+		}
+		if t := s.node.GetTopic(name); t != nil {
+			info.Peers = peerStrings(t.ListPeers())
+		}
+		if stats, ok := s.node.SubscriptionStats(name); ok {
+			info.Subscription = &stats
+		}
+		topics = append(topics, info)
+	}
+	writeJSON(w, http.StatusOK, topics)
+}
+
+// topic routes the requests of a specific topic:
+//
+//	POST /topics/{topic} subscribes the topic
+//	DELETE /topics/{topic} unsubscribes the topic
+//	POST /topics/{topic}/publish publishes the request body (application/octet-stream)
+//	GET /topics/{topic}/messages streams the messages of the topic (server-sent events)
+func (s *Server) topic(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/topics/"), "/")
+	topicName, action := path, ""
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		switch path[i+1:] {
+		case "publish", "messages":
+			topicName, action = path[:i], path[i+1:]
+		}
+	}
+	if len(topicName) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("topic is required"))
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodPost:
+		s.subscribe(w, topicName)
+	case action == "" && r.Method == http.MethodDelete:
+		s.unsubscribe(w, topicName)
+	case action == "publish" && r.Method == http.MethodPost:
+		contentType(contentTypeBinary, func(w http.ResponseWriter, r *http.Request) {
+			s.publish(w, r, topicName)
+		})(w, r)
+	case action == "messages" && r.Method == http.MethodGet:
+		s.messages(w, r, topicName)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+// subscribe subscribes the given topic, messages are consumed by the admin API streams
+func (s *Server) subscribe(w http.ResponseWriter, topicName string) {
+	if err := s.node.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not subscribe"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsubscribe closes the message streams of the given topic and unsubscribes it
+func (s *Server) unsubscribe(w http.ResponseWriter, topicName string) {
+	s.cancelStreams(topicName)
+	if err := s.node.UnSubscribe(topicName); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not unsubscribe"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publish publishes the request body on the given topic
+func (s *Server) publish(w http.ResponseWriter, r *http.Request, topicName string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "could not read message"))
+		return
+	}
+	if s.node.GetTopic(topicName) == nil {
+		writeError(w, http.StatusNotFound, errors.Errorf("topic not found: %s", topicName))
+		return
+	}
+	if err := s.node.Publish(topicName, data); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not publish"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// messages streams the messages of the given topic as server-sent events, the topic is joined if needed.
+// The stream uses a dedicated subscription so it doesn't interfere with the handler of the node.
+func (s *Server) messages(w http.ResponseWriter, r *http.Request, topicName string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	if s.node.GetTopic(topicName) == nil {
+		if err := s.node.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0); err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not subscribe"))
+			return
+		}
+	}
+	sub, err := s.newStream(topicName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer s.closeStream(topicName, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		msg, err := sub.Next(r.Context())
+		if err != nil {
+			return
+		}
+		raw, err := json.Marshal(Message{
+			Topic: msg.GetTopic(),
+			From:  msg.GetFrom().String(),
+			Data:  msg.GetData(),
+		})
+		if err != nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", raw); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// newStream creates a subscription for a message stream of the given topic
+func (s *Server) newStream(topicName string) (*pubsublibp2p.Subscription, error) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	t := s.node.GetTopic(topicName)
+	if t == nil {
+		return nil, errors.Errorf("topic not found: %s", topicName)
+	}
+	sub, err := t.Subscribe()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not subscribe")
+	}
+	if _, ok := s.streams[topicName]; !ok {
+		s.streams[topicName] = make(map[*pubsublibp2p.Subscription]struct{})
+	}
+	s.streams[topicName][sub] = struct{}{}
+	return sub, nil
+}
+
+// closeStream cancels the subscription of a message stream
+func (s *Server) closeStream(topicName string, sub *pubsublibp2p.Subscription) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	sub.Cancel()
+	delete(s.streams[topicName], sub)
+	if len(s.streams[topicName]) == 0 {
+		delete(s.streams, topicName)
+	}
+}
+
+// cancelStreams cancels the message streams of the given topic,
+// otherwise the topic can't be closed when unsubscribing
+func (s *Server) cancelStreams(topicName string) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	for sub := range s.streams[topicName] {
+		sub.Cancel()
+	}
+	delete(s.streams, topicName)
+}
+
+// protocols returns the registered stream protocols
+func (s *Server) protocols(w http.ResponseWriter, r *http.Request) {
+	protocols := s.node.Host().Mux().Protocols()
+	sort.Strings(protocols)
+	writeJSON(w, http.StatusOK, protocols)
+}
+
+// logLevel changes the log level of the given logger, or of all the facade loggers
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var err error
+	if len(req.Logger) == 0 {
+		err = logging.SetLogLevelRegex(defaultLoggers, req.Level)
+	} else {
+		err = logging.SetLogLevel(req.Logger, req.Level)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "could not set log level"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// method returns a handler that accepts only the given method
+func method(m string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// contentType returns a handler that accepts only bodies of the given media type.
+// Browsers send other sites' requests without a preflight only with form or plain text bodies
+func contentType(mediaType string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != mediaType {
+			writeError(w, http.StatusUnsupportedMediaType, errors.Errorf("content type must be %s", mediaType))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// readJSON decodes the json body of the given request
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(v); err != nil {
+		return errors.Wrap(err, "could not decode request")
+	}
+	return nil
+}
+
+// writeJSON writes the given value as a json response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debugf("could not write response: %s", err.Error())
+	}
+}
+
+// writeError writes the given error as a json response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,187 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/pubsub"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAddr is the address of the admin API if not configured
+	DefaultAddr = "127.0.0.1:5001"
+	// unixPrefix is the prefix of unix socket addresses
+	unixPrefix = "unix://"
+	// shutdownTimeout is the timeout for shutting down the server gracefully
+	shutdownTimeout = 5 * time.Second
+)
+
+var (
+	logger = logging.Logger("p2p:admin")
+)
+
+// Node is the node that is exposed by the admin API, implemented by the facade
+type Node interface {
+	Host() host.Host
+	pubsub.PubsubService
+	MeshPeers(topicName string) []peer.ID
+}
+
+// Server is an HTTP/JSON server that exposes a node
+type Server struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	node   Node
+	addr   string
+
+	lock     sync.Mutex
+	listener net.Listener
+	srv      *http.Server
+
+	streamsLock sync.Mutex
+	streams     map[string]map[*pubsublibp2p.Subscription]struct{}
+}
+
+// NewServer creates a new admin server for the given node, listening on the given address (see Listen)
+func NewServer(ctx context.Context, node Node, addr string) *Server {
+	if len(addr) == 0 {
+		addr = DefaultAddr
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Server{
+		ctx:    ctx,
+		cancel: cancel,
+		node:   node,
+		addr:   addr,
+
+		streams: make(map[string]map[*pubsublibp2p.Subscription]struct{}),
+	}
+}
+
+// Start starts to listen and serve requests in the background
+func (s *Server) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.srv != nil {
+		return nil
+	}
+	l, err := Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return s.ctx
+		},
+	}
+	s.srv = srv
+	// the server is not read from the field, which is reset once closed
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Warnf("admin server failed: %s", err.Error())
+		}
+	}()
+	logger.Infof("admin server is listening on %s", l.Addr().String())
+	return nil
+}
+
+// Addr returns the address the server is listening on, or nil if not started
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close shuts down the server, open message streams are terminated
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// cancelling the context terminates long lived requests (message streams)
+	s.cancel()
+	if s.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := s.srv.Shutdown(ctx)
+	s.srv = nil
+	return errors.Wrap(err, "could not shutdown admin server")
+}
+
+// Listen listens on the given address, which is either a unix socket (unix:///path/to/socket)
+// or a TCP address on the loopback interface
+func Listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		path := strings.TrimPrefix(addr, unixPrefix)
+		// remove a stale socket
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "could not remove existing socket")
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not listen on unix socket")
+		}
+		return l, nil
+	}
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid admin address")
+	}
+	if !isLoopback(h) {
+		return nil, errors.Errorf("admin address must be a localhost address: %s", addr)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not listen")
+	}
+	return l, nil
+}
+
+// isLoopback returns whether the given host is localhost
+func isLoopback(h string) bool {
+	if h == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(h)
+	return ip != nil && ip.IsLoopback()
+}
+
+// addrStrings returns the string representation of the given addresses
+func addrStrings(addrs []ma.Multiaddr) []string {
+	res := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		res = append(res, addr.String())
+	}
+	return res
+}
+
+// peerStrings returns the string representation of the given peers
+func peerStrings(pids []peer.ID) []string {
+	res := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		res = append(res, pid.String())
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,222 @@
+package admin_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/amirylm/libp2p-facade/admin"
+	"github.com/amirylm/libp2p-facade/facadetest"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	c, err := facadetest.NewCluster(ctx, facadetest.ClusterConfig{
+		N: 3,
+		// the last node is connected via the admin API
+		Topology: func(n int) []facadetest.Link {
+			return []facadetest.Link{{0, 1}}
+		},
+		Options: func(i int) []p2pfacade.Option {
+			if i == 2 {
+				return nil
+			}
+			return []p2pfacade.Option{p2pfacade.WithPubsubConfigurer(facadetest.NewPubsubConfigurer())}
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	node, other := c.Nodes[0], c.Nodes[1]
+
+	s := admin.NewServer(ctx, node, "")
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	var id admin.Identity
+	getJSON(t, ts.URL+"/identity", &id)
+	require.Equal(t, node.Host().ID().String(), id.ID)
+	require.NotEmpty(t, id.Addrs)
+
+	var peers []admin.PeerInfo
+	getJSON(t, ts.URL+"/peers", &peers)
+	require.Len(t, peers, 1)
+	require.Equal(t, other.Host().ID().String(), peers[0].ID)
+	require.Equal(t, "Connected", peers[0].Connectedness)
+
+	var protocols []string
+	getJSON(t, ts.URL+"/protocols", &protocols)
+	require.Contains(t, protocols, "/ipfs/id/1.0.0")
+
+	topicName := "admin.test"
+	res := do(t, http.MethodPost, ts.URL+"/topics/"+topicName, nil)
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	received := make(chan []byte, 1)
+	require.NoError(t, other.Subscribe(topicName, func(msg *pubsublibp2p.Message) {
+		received <- msg.GetData()
+	}, 0))
+	require.NoError(t, facadetest.WaitFor(ctx, func() bool {
+		return len(node.MeshPeers(topicName)) > 0 && len(other.MeshPeers(topicName)) > 0
+	}))
+
+	var topics []admin.TopicInfo
+	getJSON(t, ts.URL+"/topics", &topics)
+	require.Len(t, topics, 1)
+	require.Equal(t, topicName, topics[0].Name)
+	require.Equal(t, []string{other.Host().ID().String()}, topics[0].Peers)
+	require.Equal(t, []string{other.Host().ID().String()}, topics[0].MeshPeers)
+	require.NotNil(t, topics[0].Subscription)
+
+	// publish via admin API
+	res = do(t, http.MethodPost, ts.URL+"/topics/"+topicName+"/publish", []byte("from admin"))
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	select {
+	case data := <-received:
+		require.Equal(t, "from admin", string(data))
+	case <-ctx.Done():
+		t.Fatal("message was not received")
+	}
+	res = do(t, http.MethodPost, ts.URL+"/topics/unknown/publish", []byte("x"))
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// stream messages
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	req, err := http.NewRequestWithContext(sctx, http.MethodGet, ts.URL+"/topics/"+topicName+"/messages", nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	require.NoError(t, other.Publish(topicName, []byte("to admin")))
+	scanner := bufio.NewScanner(res.Body)
+	var msg admin.Message
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data: ") {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg))
+			break
+		}
+	}
+	require.Equal(t, topicName, msg.Topic)
+	require.Equal(t, other.Host().ID().String(), msg.From)
+	require.Equal(t, "to admin", string(msg.Data))
+
+	res = do(t, http.MethodDelete, ts.URL+"/topics/"+topicName, nil)
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Empty(t, node.Topics())
+
+	res = do(t, http.MethodPut, ts.URL+"/log-level", []byte(`{"level":"info"}`))
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(t, http.MethodPut, ts.URL+"/log-level", []byte(`{"level":"loud"}`))
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// connect and disconnect a node without pubsub, which won't reconnect on its own
+	_, err = c.Net.LinkPeers(node.Host().ID(), c.ID(2))
+	require.NoError(t, err)
+	pi := c.AddrInfo(2)
+	addrs, err := peer.AddrInfoToP2pAddrs(&pi)
+	require.NoError(t, err)
+	res = do(t, http.MethodPost, ts.URL+"/peers/connect", []byte(`{"addr":"`+addrs[0].String()+`"}`))
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Len(t, node.Host().Network().Peers(), 2)
+	res = do(t, http.MethodPost, ts.URL+"/peers/disconnect", []byte(`{"id":"`+c.ID(2).String()+`"}`))
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Equal(t, network.NotConnected, node.Host().Network().Connectedness(c.ID(2)))
+	res = do(t, http.MethodPost, ts.URL+"/peers/connect", []byte(`{"addr":"invalid"}`))
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = do(t, http.MethodGet, ts.URL+"/peers/connect", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+
+	t.Run("cross site requests", func(t *testing.T) {
+		body := []byte(`{"level":"info"}`)
+		// simple requests that browsers send without a preflight
+		res := doWithHeaders(t, http.MethodPut, ts.URL+"/log-level", body, map[string]string{"Content-Type": "text/plain"})
+		require.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+		res = doWithHeaders(t, http.MethodPost, ts.URL+"/topics/"+topicName+"/publish", []byte("x"),
+			map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+		require.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+		// DNS rebinding
+		res = doWithHeaders(t, http.MethodPut, ts.URL+"/log-level", body,
+			map[string]string{"Content-Type": "application/json", "Host": "attacker.example:5001"})
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+		res = doWithHeaders(t, http.MethodPost, ts.URL+"/topics/"+topicName, nil,
+			map[string]string{"Origin": "http://attacker.example"})
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+		require.Empty(t, node.Topics())
+
+		res = doWithHeaders(t, http.MethodPut, ts.URL+"/log-level", body,
+			map[string]string{"Content-Type": "application/json; charset=utf-8", "Host": "localhost", "Origin": "http://127.0.0.1:5001"})
+		require.Equal(t, http.StatusNoContent, res.StatusCode)
+	})
+}
+
+func TestListen(t *testing.T) {
+	_, err := admin.Listen("0.0.0.0:0")
+	require.Error(t, err)
+	_, err = admin.Listen("invalid")
+	require.Error(t, err)
+
+	l, err := admin.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	l, err = admin.Listen("unix://" + sock)
+	require.NoError(t, err)
+	require.Equal(t, sock, l.Addr().String())
+	require.NoError(t, l.Close())
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	res := do(t, http.MethodGet, url, nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+}
+
+func do(t *testing.T, method, url string, body []byte) *http.Response {
+	contentType := "application/json"
+	if strings.HasSuffix(url, "/publish") {
+		contentType = "application/octet-stream"
+	}
+	return doWithHeaders(t, method, url, body, map[string]string{"Content-Type": contentType})
+}
+
+func doWithHeaders(t *testing.T, method, url string, body []byte, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range headers {
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = res.Body.Close()
+	})
+	return res
+}
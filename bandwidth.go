@@ -0,0 +1,94 @@
+package p2pfacade
+
+import (
+	"sort"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	libp2pmetrics "github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	bandwidthSubsystem = "bandwidth"
+	// bandwidthIdleTimeout is the time without traffic before the stats of a peer or a protocol are trimmed
+	bandwidthIdleTimeout = time.Hour
+)
+
+// bandwidthCollector exports the stats of a bandwidth counter as prometheus metrics, by protocol and by peer.
+// Only the peers with the most traffic are reported, to limit the cardinality of the metrics.
+type bandwidthCollector struct {
+	counter  *libp2pmetrics.BandwidthCounter
+	maxPeers int
+
+	totalBytes    *prometheus.Desc
+	protocolBytes *prometheus.Desc
+	protocolRate  *prometheus.Desc
+	peerBytes     *prometheus.Desc
+	peerRate      *prometheus.Desc
+}
+
+func newBandwidthCollector(opts commons.MetricsOpts, counter *libp2pmetrics.BandwidthCounter, maxPeers int) *bandwidthCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, bandwidthSubsystem, name), help,
+			append(labels, "direction"), opts.ConstLabels)
+	}
+	return &bandwidthCollector{
+		counter:       counter,
+		maxPeers:      maxPeers,
+		totalBytes:    desc("bytes", "Counts bytes by direction (in, out)"),
+		protocolBytes: desc("protocol_bytes", "Counts bytes by protocol and direction", "protocol"),
+		protocolRate:  desc("protocol_rate", "Bytes per second by protocol and direction", "protocol"),
+		peerBytes:     desc("peer_bytes", "Counts bytes by peer and direction, for the peers with the most traffic", "peer"),
+		peerRate:      desc("peer_rate", "Bytes per second by peer and direction, for the peers with the most traffic", "peer"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (bc *bandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bc.totalBytes
+	ch <- bc.protocolBytes
+	ch <- bc.protocolRate
+	ch <- bc.peerBytes
+	ch <- bc.peerRate
+}
+
+// Collect implements prometheus.Collector
+func (bc *bandwidthCollector) Collect(ch chan<- prometheus.Metric) {
+	totals := bc.counter.GetBandwidthTotals()
+	ch <- prometheus.MustNewConstMetric(bc.totalBytes, prometheus.CounterValue, float64(totals.TotalIn), "in")
+	ch <- prometheus.MustNewConstMetric(bc.totalBytes, prometheus.CounterValue, float64(totals.TotalOut), "out")
+
+	for proto, stats := range bc.counter.GetBandwidthByProtocol() {
+		bc.collectStats(ch, bc.protocolBytes, bc.protocolRate, string(proto), stats)
+	}
+
+	if bc.maxPeers <= 0 {
+		return
+	}
+	byPeer := bc.counter.GetBandwidthByPeer()
+	peers := make([]peer.ID, 0, len(byPeer))
+	for pid := range byPeer {
+		peers = append(peers, pid)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		a, b := byPeer[peers[i]], byPeer[peers[j]]
+		return a.TotalIn+a.TotalOut > b.TotalIn+b.TotalOut
+	})
+	if len(peers) > bc.maxPeers {
+		peers = peers[:bc.maxPeers]
+	}
+	for _, pid := range peers {
+		bc.collectStats(ch, bc.peerBytes, bc.peerRate, pid.String(), byPeer[pid])
+	}
+}
+
+// collectStats sends the bytes and rates of the given stats
+func (bc *bandwidthCollector) collectStats(ch chan<- prometheus.Metric, bytesDesc, rateDesc *prometheus.Desc,
+	label string, stats libp2pmetrics.Stats) {
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(stats.TotalIn), label, "in")
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(stats.TotalOut), label, "out")
+	ch <- prometheus.MustNewConstMetric(rateDesc, prometheus.GaugeValue, stats.RateIn, label, "in")
+	ch <- prometheus.MustNewConstMetric(rateDesc, prometheus.GaugeValue, stats.RateOut, label, "out")
+}
@@ -0,0 +1,155 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/libp2p/go-libp2p-core/host"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	nodes := make([]Facade, 3)
+	for i := range nodes {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.PubsubConfigurer = pubsub.NewNilConfigurer()
+		opts := []Option{WithConfig(cfg), WithMetricsRegistry(nil)}
+		if i == 0 {
+			cfg.Bandwidth.MaxPeers = 1
+			opts = append(opts, WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+		}
+		f, err := New(ctx, opts...)
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+
+	topicName := "test.bandwidth"
+	for _, f := range nodes {
+		require.NoError(t, f.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	}
+	for _, f := range nodes[1:] {
+		require.NoError(t, nodes[0].Host().Connect(ctx, *host.InfoFromHost(f.Host())))
+	}
+	require.Eventually(t, func() bool {
+		return len(nodes[0].GetTopic(topicName).ListPeers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	data := []byte("bandwidth")
+	require.NoError(t, nodes[0].Publish(topicName, data))
+	require.Equal(t, float64(len(data)), gatherValue(t, reg, "test_pubsub_out_bytes", "topic", topicName))
+
+	// the bandwidth counter is updated periodically
+	require.Eventually(t, func() bool {
+		return gatherValue(t, reg, "test_bandwidth_bytes", "direction", "out") > 0 &&
+			gatherValue(t, reg, "test_bandwidth_protocol_bytes", "protocol", string(pubsublibp2p.GossipSubID_v11)) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+	// only the peer with the most traffic is reported
+	count, err := testutil.GatherAndCount(reg, "test_bandwidth_peer_bytes")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestBandwidthPerFacade(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// facades of the same config count their own bandwidth
+	cfg := &config.Config{}
+	cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	nodes := make([]*facade, 2)
+	for i := range nodes {
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		nodes[i] = f.(*facade)
+		defer func() {
+			require.NoError(t, f.Close())
+		}()
+	}
+	require.Nil(t, cfg.BandwidthCounter)
+	require.NotNil(t, nodes[0].bandwidth)
+	require.NotNil(t, nodes[1].bandwidth)
+	require.NotSame(t, nodes[0].bandwidth, nodes[1].bandwidth)
+
+	cfg = &config.Config{}
+	cfg.Bandwidth.Disabled = true
+	f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+	require.NoError(t, err)
+	require.Nil(t, f.(*facade).bandwidth)
+	require.NoError(t, f.Close())
+
+	// the collector of a closed facade is unregistered, so it doesn't pin its counter
+	reg := prometheus.NewRegistry()
+	newNode := func(node string) Facade {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(reg), WithMetricsLabels(prometheus.Labels{"node": node}))
+		require.NoError(t, err)
+		return f
+	}
+	series := func() int {
+		count, err := testutil.GatherAndCount(reg, "p2p_bandwidth_bytes")
+		require.NoError(t, err)
+		return count
+	}
+	a, b := newNode("a"), newNode("b")
+	require.Equal(t, 4, series())
+	// the collector of a facade with the same labels is not registered
+	dup := newNode("a")
+	require.Equal(t, 4, series())
+	require.NoError(t, a.Close())
+	require.Equal(t, 2, series())
+	require.NoError(t, dup.Close())
+	a = newNode("a")
+	require.Equal(t, 4, series())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.Equal(t, 0, series())
+}
+
+// gatherValue returns the sum of the values of the given counter, with the given label value
+func gatherValue(t *testing.T, reg prometheus.Gatherer, name, label, value string) float64 {
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	sum := 0.0
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if hasLabel(m, label, value) {
+				sum += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return sum
+}
+
+// hasLabel returns true if the metric has the given label value, or if no label was given
+func hasLabel(m *dto.Metric, label, value string) bool {
+	if len(label) == 0 {
+		return true
+	}
+	for _, l := range m.GetLabel() {
+		if l.GetName() == label && l.GetValue() == value {
+			return true
+		}
+	}
+	return false
+}
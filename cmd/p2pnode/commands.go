@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/streams"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/pkg/errors"
+)
+
+const (
+	// pollInterval is the interval used when waiting for peers
+	pollInterval = 100 * time.Millisecond
+)
+
+// newFlagSet creates a flag set for the given command
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: p2pnode %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the given flags and ensures the number of positional arguments
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return errors.Errorf("expected %d arguments, got %d", n, fs.NArg())
+	}
+	return nil
+}
+
+// closeNode closes the given node, and reports the error if the command did not fail
+func closeNode(f p2pfacade.Facade, err *error) {
+	if cerr := f.Close(); cerr != nil && *err == nil {
+		*err = cerr
+	}
+}
+
+func runCmd(ctx context.Context, args []string) (err error) {
+	var nf nodeFlags
+	var topics, echo string
+	fs := newFlagSet("run", "")
+	nf.register(fs)
+	fs.StringVar(&topics, "topics", "", "comma separated topics to subscribe, messages are printed to stdout")
+	fs.StringVar(&echo, "echo", "", "comma separated protocols to handle by echoing the request")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	f, err := nf.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeNode(f, &err)
+
+	for _, topicName := range splitList(topics) {
+		if err := f.Subscribe(topicName, printMsg, 0); err != nil {
+			return errors.Wrapf(err, "could not subscribe topic %s", topicName)
+		}
+	}
+	for _, p := range splitList(echo) {
+		if err := f.SetStreamHandler(protocol.ID(p), echoHandler(f.StreamConfig()), config.ResourceLimit{}); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("node is running, peer ID:", f.Host().ID())
+	printAddrs(f.Host())
+	if addr := f.AdminAddr(); addr != nil {
+		fmt.Println("admin API is listening on", addr.String())
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func idCmd(ctx context.Context, args []string) error {
+	var nf nodeFlags
+	fs := newFlagSet("id", "")
+	nf.register(fs)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	sk, err := nf.identity()
+	if err != nil {
+		return err
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+	fmt.Println(pid.String())
+	return nil
+}
+
+func peersCmd(ctx context.Context, args []string) (err error) {
+	var nf nodeFlags
+	var wait time.Duration
+	fs := newFlagSet("peers", "")
+	nf.register(fs)
+	fs.DurationVar(&wait, "wait", 0, "time to wait for other peers (e.g. mdns or DHT) before printing")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	f, err := nf.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeNode(f, &err)
+
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+	for _, pid := range f.Host().Network().Peers() {
+		fmt.Println(pid.String())
+		for _, addr := range f.Host().Peerstore().Addrs(pid) {
+			fmt.Println("  ", addr.String())
+		}
+	}
+	return nil
+}
+
+func publishCmd(ctx context.Context, args []string) (err error) {
+	var nf nodeFlags
+	fs := newFlagSet("publish", "<topic> <msg>")
+	nf.register(fs)
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	topicName, msg := fs.Arg(0), fs.Arg(1)
+	f, err := nf.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeNode(f, &err)
+
+	if err := f.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0); err != nil {
+		return errors.Wrapf(err, "could not join topic %s", topicName)
+	}
+	if err := waitForTopicPeers(ctx, f, topicName, nf.timeout); err != nil {
+		return err
+	}
+	if err := f.Publish(topicName, []byte(msg)); err != nil {
+		return errors.Wrap(err, "could not publish")
+	}
+	fmt.Println("published message on topic", topicName)
+	return nil
+}
+
+func subscribeCmd(ctx context.Context, args []string) (err error) {
+	var nf nodeFlags
+	fs := newFlagSet("subscribe", "<topic>")
+	nf.register(fs)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	topicName := fs.Arg(0)
+	f, err := nf.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeNode(f, &err)
+
+	if err := f.Subscribe(topicName, printMsg, 0); err != nil {
+		return errors.Wrapf(err, "could not subscribe topic %s", topicName)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func requestCmd(ctx context.Context, args []string) (err error) {
+	var nf nodeFlags
+	fs := newFlagSet("request", "<peer> <protocol> <payload>")
+	nf.register(fs)
+	if err := parseArgs(fs, args, 3); err != nil {
+		return err
+	}
+	pi, err := peer.AddrInfoFromString(fs.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "invalid peer address")
+	}
+	f, err := nf.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeNode(f, &err)
+
+	if err := connect(ctx, f, []peer.AddrInfo{*pi}, nf.timeout); err != nil {
+		return err
+	}
+	cfg := f.StreamConfig()
+	cfg.Timeout = nf.timeout
+	res, err := streams.Request(pi.ID, protocol.ID(fs.Arg(1)), []byte(fs.Arg(2)), cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(res))
+	return nil
+}
+
+func keygenCmd(ctx context.Context, args []string) error {
+	var out, keyType string
+	var force bool
+	fs := newFlagSet("keygen", "")
+	fs.StringVar(&out, "out", "", "path of the key file (required)")
+	fs.StringVar(&keyType, "type", "ed25519", "key type (ed25519, secp256k1, ecdsa, rsa)")
+	fs.BoolVar(&force, "force", false, "overwrite an existing key file")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		fs.Usage()
+		return errors.New("key file is required (-out)")
+	}
+	if _, err := os.Stat(out); err == nil && !force {
+		return errors.Errorf("key file %s already exists", out)
+	}
+	sk, err := generateKey(keyType)
+	if err != nil {
+		return err
+	}
+	if err := config.SavePrivateKey(out, sk); err != nil {
+		return err
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+	fmt.Println(pid.String())
+	return nil
+}
+
+// generateKey generates a new private key of the given type
+func generateKey(keyType string) (crypto.PrivKey, error) {
+	var typ int
+	bits := -1
+	switch keyType {
+	case "ed25519":
+		typ = crypto.Ed25519
+	case "secp256k1":
+		typ = crypto.Secp256k1
+	case "ecdsa":
+		typ = crypto.ECDSA
+	case "rsa":
+		typ, bits = crypto.RSA, 2048
+	default:
+		return nil, errors.Errorf("unsupported key type %s", keyType)
+	}
+	sk, _, err := crypto.GenerateKeyPair(typ, bits)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate key")
+	}
+	return sk, nil
+}
+
+// waitForTopicPeers waits until the node knows at least one peer that is subscribed to the given topic
+func waitForTopicPeers(ctx context.Context, f p2pfacade.Facade, topicName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for len(f.GetTopic(topicName).ListPeers()) == 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Errorf("no peers found for topic %s", topicName)
+		}
+	}
+	return nil
+}
+
+// printMsg prints the given pubsub message
+func printMsg(msg *pubsublibp2p.Message) {
+	fmt.Printf("[%s] %s: %s\n", msg.GetTopic(), msg.GetFrom(), string(msg.GetData()))
+}
+
+// echoHandler returns a stream handler that responds with the request
+func echoHandler(cfg streams.StreamConfig) network.StreamHandler {
+	return func(stream network.Stream) {
+		req, respond, done, err := streams.HandleStreamWithConfig(stream, cfg)
+		defer func() {
+			_ = done()
+		}()
+		if err != nil {
+			return
+		}
+		_ = respond(req)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `p2pnode is a command line tool for running and debugging libp2p facade nodes
+
+Usage:
+  p2pnode <command> [flags] [args]
+
+Commands:
+  run                                   run a node until interrupted
+  id                                    print the peer ID of the node
+  peers                                 connect to the given peers and print the connected peers
+  publish <topic> <msg>                 publish a message on the given topic
+  subscribe <topic>                     print the messages of the given topic until interrupted
+  request <peer> <protocol> <payload>   send a stream request to the given peer (multiaddr) and print the response
+  keygen                                generate a new identity key
+
+Use "p2pnode <command> -h" for the flags of a command.
+`
+
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"run":       runCmd,
+	"id":        idCmd,
+	"peers":     peersCmd,
+	"publish":   publishCmd,
+	"subscribe": subscribeCmd,
+	"request":   requestCmd,
+	"keygen":    keygenCmd,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := cmd(ctx, os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err.Error())
+		cancel()
+		os.Exit(1)
+	}
+}
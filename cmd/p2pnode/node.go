@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+// nodeFlags are the flags used to create a node
+type nodeFlags struct {
+	configPath string
+	keyPath    string
+	listen     string
+	connect    string
+	dht        bool
+	timeout    time.Duration
+	logLevel   string
+}
+
+func (nf *nodeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&nf.configPath, "config", "", "path to a config file (yaml/json)")
+	fs.StringVar(&nf.keyPath, "key", "", "path to the identity key, a new key is created if the file does not exist (default: ephemeral key)")
+	fs.StringVar(&nf.listen, "listen", "", "comma separated listen addresses, overrides the config")
+	fs.StringVar(&nf.connect, "connect", "", "comma separated addresses of peers to connect (multiaddr with /p2p/)")
+	fs.BoolVar(&nf.dht, "dht", false, "use kademlia DHT for routing, connected peers and bootstrap peers from the config are used as bootstrappers")
+	fs.DurationVar(&nf.timeout, "timeout", defaultTimeout, "timeout for connecting and waiting on peers")
+	fs.StringVar(&nf.logLevel, "log-level", "", "log level of the facade components (debug, info, warn, error)")
+}
+
+// config creates the node config from the config file and the given flags
+func (nf *nodeFlags) config() (*config.Config, error) {
+	cfg := &config.Config{}
+	if len(nf.configPath) > 0 {
+		static, err := config.LoadStaticConfig(nf.configPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.StaticConfig = *static
+	}
+	if len(nf.listen) > 0 {
+		cfg.ListenAddrs = splitList(nf.listen)
+	}
+	if len(cfg.ListenAddrs) == 0 {
+		cfg.ListenAddrs = []string{"/ip4/0.0.0.0/tcp/0"}
+	}
+	sk, err := nf.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	cfg.PrivateKey = sk
+	cfg.PubsubConfigurer = pubsub.NewNilConfigurer()
+	return cfg, nil
+}
+
+// privateKey loads the identity key, or creates it if the file does not exist.
+// An ephemeral key is used if no path was provided.
+func (nf *nodeFlags) privateKey() (crypto.PrivKey, error) {
+	if len(nf.keyPath) == 0 {
+		return nil, nil
+	}
+	sk, err := config.LoadPrivateKey(nf.keyPath)
+	if err == nil {
+		return sk, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	sk, _, err = crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate key")
+	}
+	if err := config.SavePrivateKey(nf.keyPath, sk); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// identity returns the identity key of the node, from the key file or from the data directory of the config.
+// The key is created if it does not exist, the same way the node does
+func (nf *nodeFlags) identity() (crypto.PrivKey, error) {
+	cfg, err := nf.config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PrivateKey == nil && len(cfg.DataDir) == 0 {
+		return nil, errors.New("identity key is required (-key or dataDir in the config)")
+	}
+	if err := cfg.Init(); err != nil {
+		return nil, err
+	}
+	return cfg.PrivateKey, nil
+}
+
+// peers returns the peers to connect
+func (nf *nodeFlags) peers() ([]peer.AddrInfo, error) {
+	addrs := splitList(nf.connect)
+	peers := make([]peer.AddrInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		pi, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid peer address %s", addr)
+		}
+		peers = append(peers, *pi)
+	}
+	return peers, nil
+}
+
+// start creates and starts a node, and connects to the given peers
+func (nf *nodeFlags) start(ctx context.Context) (p2pfacade.Facade, error) {
+	if len(nf.logLevel) > 0 {
+		if err := logging.SetLogLevelRegex("p2p:.*", nf.logLevel); err != nil {
+			return nil, errors.Wrap(err, "invalid log level")
+		}
+	}
+	cfg, err := nf.config()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := nf.peers()
+	if err != nil {
+		return nil, err
+	}
+	opts := []p2pfacade.Option{p2pfacade.WithConfig(cfg)}
+	if nf.dht {
+		bootstrappers, err := p2pfacade.ResolvePeers(ctx, madns.DefaultResolver, cfg.BootstrapPeers)
+		if err != nil {
+			return nil, err
+		}
+		bootstrappers = append(bootstrappers, peers...)
+		opts = append(opts, p2pfacade.WithRouting(func(h host.Host) (routing.Routing, error) {
+			r, _, err := p2pfacade.NewKadDHT(ctx, h, dht.DefaultPrefix, dht.ModeAuto, bootstrappers)
+			return r, err
+		}))
+	}
+	f, err := p2pfacade.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Start(nil); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := connect(ctx, f, peers, nf.timeout); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// connect connects to the given peers
+func connect(ctx context.Context, f p2pfacade.Facade, peers []peer.AddrInfo, timeout time.Duration) error {
+	var err error
+	for _, pi := range peers {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		err = multierr.Append(err, errors.Wrapf(f.Host().Connect(cctx, pi), "could not connect to %s", pi.ID))
+		cancel()
+	}
+	return err
+}
+
+// printAddrs prints the addresses of the given host
+func printAddrs(h host.Host) {
+	addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(h))
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		fmt.Println(addr.String())
+	}
+}
+
+// splitList splits a comma separated list
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeFlags(t *testing.T) {
+	nf := nodeFlags{
+		configPath: "../../config/example.yaml",
+		keyPath:    filepath.Join(t.TempDir(), "node.key"),
+		listen:     "/ip4/127.0.0.1/tcp/0, /ip4/127.0.0.1/udp/0/quic",
+	}
+	cfg, err := nf.config()
+	require.NoError(t, err)
+	require.Equal(t, []string{"/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic"}, cfg.ListenAddrs)
+	require.Equal(t, "mynet/latest", cfg.UserAgent)
+	require.NotNil(t, cfg.PrivateKey)
+
+	// the key should be loaded from the file that was created
+	cfg2, err := nf.config()
+	require.NoError(t, err)
+	require.True(t, cfg.PrivateKey.Equals(cfg2.PrivateKey))
+
+	nf.connect = "/ip4/127.0.0.1/tcp/4001"
+	_, err = nf.peers()
+	require.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	_, err := (&nodeFlags{}).identity()
+	require.Error(t, err)
+
+	// the key is loaded from the data directory of the config, the same way the node does
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte("dataDir: "+filepath.Join(dir, "data")+"\n"), 0600))
+	nf := nodeFlags{configPath: configPath}
+	sk, err := nf.identity()
+	require.NoError(t, err)
+	require.NotNil(t, sk)
+	cfg, err := nf.config()
+	require.NoError(t, err)
+	require.NoError(t, cfg.Init())
+	require.True(t, sk.Equals(cfg.PrivateKey))
+
+	// the key file has precedence
+	nf.keyPath = filepath.Join(dir, "node.key")
+	sk2, err := nf.identity()
+	require.NoError(t, err)
+	require.False(t, sk.Equals(sk2))
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, typ := range []string{"ed25519", "secp256k1", "ecdsa"} {
+		sk, err := generateKey(typ)
+		require.NoError(t, err)
+		require.NotNil(t, sk)
+	}
+	_, err := generateKey("dsa")
+	require.Error(t, err)
+}
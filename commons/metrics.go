@@ -0,0 +1,112 @@
+package commons
+
+import (
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultMetricsNamespace is the default namespace of metrics
+	DefaultMetricsNamespace = "p2p"
+)
+
+var (
+	logger = logging.Logger("p2p:metrics")
+)
+
+// MetricsOpts contains the options used to create metrics
+type MetricsOpts struct {
+	// Registerer is used to register metrics, if nil the metrics won't be registered (no-op)
+	Registerer prometheus.Registerer
+	// Namespace is the namespace of the metrics
+	Namespace string
+	// ConstLabels are labels that are added to all metrics
+	ConstLabels prometheus.Labels
+}
+
+// DefaultMetricsOpts returns metrics options that uses the default prometheus registerer
+func DefaultMetricsOpts() MetricsOpts {
+	return MetricsOpts{
+		Registerer: prometheus.DefaultRegisterer,
+		Namespace:  DefaultMetricsNamespace,
+	}
+}
+
+// RegisterCollector registers the given collector and returns it.
+// Components that share a registerer must use different namespaces or const labels.
+// In case an equal collector was already registered, a warning is logged and the given collector
+// is returned unregistered, so it is still usable but its metrics are not exported.
+func RegisterCollector[C prometheus.Collector](opts MetricsOpts, c C) C {
+	if opts.Registerer == nil {
+		return c
+	}
+	if err := opts.Registerer.Register(c); err != nil {
+		// invalid, inconsistent or already registered collector
+		logger.Warnf("could not register metrics collector: %v", err)
+	}
+	return c
+}
+
+// Registerer wraps a prometheus.Registerer and keeps the collectors that were registered,
+// so they can be unregistered once their owner is closed
+type Registerer struct {
+	prometheus.Registerer
+
+	lock       sync.Mutex
+	collectors []prometheus.Collector
+}
+
+var _ prometheus.Registerer = (*Registerer)(nil)
+
+// NewRegisterer creates a new registerer that registers collectors with the given registerer
+func NewRegisterer(reg prometheus.Registerer) *Registerer {
+	return &Registerer{Registerer: reg}
+}
+
+// Register implements prometheus.Registerer
+func (r *Registerer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+// MustRegister implements prometheus.Registerer
+func (r *Registerer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Unregister implements prometheus.Registerer
+func (r *Registerer) Unregister(c prometheus.Collector) bool {
+	r.lock.Lock()
+	for i, rc := range r.collectors {
+		if rc == c {
+			r.collectors = append(r.collectors[:i], r.collectors[i+1:]...)
+			break
+		}
+	}
+	r.lock.Unlock()
+
+	return r.Registerer.Unregister(c)
+}
+
+// UnregisterAll unregisters all the collectors that were registered
+func (r *Registerer) UnregisterAll() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, c := range r.collectors {
+		r.Registerer.Unregister(c)
+	}
+	r.collectors = nil
+}
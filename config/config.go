@@ -8,13 +8,18 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/metrics"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/pnet"
 	"github.com/libp2p/go-libp2p-core/routing"
 	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
@@ -23,12 +28,28 @@ const (
 	yamuxID = "/yamux/1.0.0"
 )
 
+// reachability values for NATConfig
+const (
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+)
+
 // StaticConfig contains static configuration for the p2p node
 type StaticConfig struct {
 	// ListenAddrs addrs to listen, this allows to specify also the transports that are supported
 	ListenAddrs []string `json:"listenAddrs" yaml:"listenAddrs"`
-	// Relayers are possible circuit relay end-points
+	// Relayers are possible circuit relay end-points. DNS multiaddrs (dns, dns4, dns6, dnsaddr) are resolved
+	// and re-resolved periodically, relayers that are resolved later are used by autorelay as well
 	Relayers []string `json:"relayers,omitempty" yaml:"relayers,omitempty"`
+	// BootstrapPeers are multiaddrs (with /p2p/) of peers that are connected on start, and used to bootstrap routing.
+	// DNS multiaddrs (dns, dns4, dns6, dnsaddr) are resolved on start and re-resolved periodically
+	BootstrapPeers []string `json:"bootstrapPeers,omitempty" yaml:"bootstrapPeers,omitempty"`
+	// DNSResolveInterval is the interval of re-resolving the DNS multiaddrs of bootstrap peers and relayers,
+	// 10m is used if zero
+	DNSResolveInterval time.Duration `json:"dnsResolveInterval,omitempty" yaml:"dnsResolveInterval,omitempty"`
+	// StaticPeers are multiaddrs (with /p2p/) of peers that we always keep connected,
+	// they are protected from trimming and reconnected whenever they disconnect
+	StaticPeers []string `json:"staticPeers,omitempty" yaml:"staticPeers,omitempty"`
 	// DialTimeout is the timeout to use when dialing peers
 	DialTimeout time.Duration `json:"dialTimeout,omitempty" yaml:"dialTimeout,omitempty"`
 	// Muxers the supported muxers
@@ -37,14 +58,154 @@ type StaticConfig struct {
 	Security []string `json:"security,omitempty" yaml:"security,omitempty"`
 	// NetworkSecret is a secret to use for a private network
 	NetworkSecret string `json:"networkSecret,omitempty" yaml:"networkSecret,omitempty"`
-	// DisablePing is a negative flag to turn off libp2p Ping
+	// DisablePing is a negative flag to turn off libp2p Ping, which also disables the latency monitor
 	DisablePing bool `json:"disablePing,omitempty" yaml:"disablePing,omitempty"`
+	// Latency configures the latency monitor, which periodically pings connected peers
+	Latency LatencyConfig `json:"latency,omitempty" yaml:"latency,omitempty"`
+	// Bandwidth configures bandwidth accounting
+	Bandwidth BandwidthConfig `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
 	// EnableAutoRelay whether to enable auto relay
 	EnableAutoRelay bool `json:"enableAutoRelay,omitempty" yaml:"enableAutoRelay,omitempty"`
+	// RelayService configures the circuit relay v2 service, which allows the node to act as a relay
+	RelayService RelayServiceConfig `json:"relayService,omitempty" yaml:"relayService,omitempty"`
+	// TopicDiscovery configures the discovery of peers for the topics we join, using the discovery of the routing
+	TopicDiscovery TopicDiscoveryConfig `json:"topicDiscovery,omitempty" yaml:"topicDiscovery,omitempty"`
+	// Discovery configures the discovery of peers to connect from all sources, e.g. mdns or routing
+	Discovery DiscoveryConfig `json:"discovery,omitempty" yaml:"discovery,omitempty"`
+	// PeerExchange configures peer exchange, which allows connected peers to ask for a sample of our peers
+	PeerExchange PeerExchangeConfig `json:"peerExchange,omitempty" yaml:"peerExchange,omitempty"`
+	// Rendezvous configures the rendezvous protocol, as a server and as a client of rendezvous points
+	Rendezvous RendezvousConfig `json:"rendezvous,omitempty" yaml:"rendezvous,omitempty"`
+	// NAT configures NAT traversal
+	NAT NATConfig `json:"nat,omitempty" yaml:"nat,omitempty"`
 	// MdnsServiceTag is the service tag used by mdns service. mdns is disabled if service tag is empty
 	MdnsServiceTag string `json:"mdnsServiceTag,omitempty" yaml:"mdnsServiceTag,omitempty"`
 	// UserAgent is the user agent string used by identify protocol
 	UserAgent string `json:"userAgent,omitempty" yaml:"userAgent,omitempty"`
+	// DataDir is a directory for persisting the private key and the peerstore of the node across restarts.
+	// The private key in the data directory is used if no other key was provided. Nothing is persisted if empty
+	DataDir string `json:"dataDir,omitempty" yaml:"dataDir,omitempty"`
+	// Admin configures the admin API of the node
+	Admin AdminConfig `json:"admin,omitempty" yaml:"admin,omitempty"`
+	// ConnManager configures the connection manager, connections are not trimmed if not configured
+	ConnManager ConnManagerConfig `json:"connManager,omitempty" yaml:"connManager,omitempty"`
+	// Gater configures the connection gater, which can be updated in runtime
+	Gater GaterConfig `json:"gater,omitempty" yaml:"gater,omitempty"`
+	// ResourceManager configures the limits of the resource manager
+	ResourceManager ResourceManagerConfig `json:"resourceManager,omitempty" yaml:"resourceManager,omitempty"`
+}
+
+// TopicDiscoveryConfig contains the configuration of topic discovery,
+// which advertises the topics we join and finds peers for topics with not enough mesh peers
+type TopicDiscoveryConfig struct {
+	// Disabled turns off topic discovery
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// MinMeshPeers is the number of mesh peers of a topic below which we look for more peers, gossipsub Dlo is used if zero
+	MinMeshPeers int `json:"minMeshPeers,omitempty" yaml:"minMeshPeers,omitempty"`
+	// Interval is the interval of checking the topics, 1m is used if zero
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Limit is the max number of peers to find in each lookup, 16 is used if zero
+	Limit int `json:"limit,omitempty" yaml:"limit,omitempty"`
+}
+
+// DiscoveryConfig contains the configuration of peer discovery,
+// found peers of all sources are deduplicated and rate limited before they are connected
+type DiscoveryConfig struct {
+	// Namespace is advertised and looked up with the discovery of the routing, turned off if empty
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Interval is the interval of namespace lookups, 1m is used if zero
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// DedupTTL is the duration in which a found peer is ignored by all sources, 1m is used if zero
+	DedupTTL time.Duration `json:"dedupTTL,omitempty" yaml:"dedupTTL,omitempty"`
+	// RateLimits is the max number of peers per minute that are accepted from each source by name, unlimited if missing
+	RateLimits map[string]int `json:"rateLimits,omitempty" yaml:"rateLimits,omitempty"`
+}
+
+// PeerExchangeConfig contains the configuration of peer exchange (PX).
+// Only peers with signed peer records are exchanged, so the addresses are certified by the peers themselves
+type PeerExchangeConfig struct {
+	// Enabled turns on peer exchange, peers are requested from bootstrap peers once connected
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// MaxPeers is the max number of peers in a response, 16 is used if zero
+	MaxPeers int `json:"maxPeers,omitempty" yaml:"maxPeers,omitempty"`
+	// RateLimit is the max number of requests per minute that are served for each peer, 2 is used if zero
+	RateLimit int `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+	// Protocols filters the peers that we request, only peers that support all the protocols are returned
+	Protocols []string `json:"protocols,omitempty" yaml:"protocols,omitempty"`
+	// Topics filters the peers that we request, only peers that are subscribed to all the topics are returned
+	Topics []string `json:"topics,omitempty" yaml:"topics,omitempty"`
+}
+
+// NATConfig contains the configuration of NAT traversal.
+// The reachability of the node is detected with AutoNAT client unless configured
+type NATConfig struct {
+	// PortMap tries to open a port in the router of the node using UPnP or NAT-PMP
+	PortMap bool `json:"portMap,omitempty" yaml:"portMap,omitempty"`
+	// AutoNATService enables the AutoNAT service, which helps other peers to detect their reachability
+	AutoNATService bool `json:"autoNATService,omitempty" yaml:"autoNATService,omitempty"`
+	// HolePunching enables hole punching (DCUtR), to upgrade relayed connections into direct connections
+	HolePunching bool `json:"holePunching,omitempty" yaml:"holePunching,omitempty"`
+	// Reachability forces the reachability of the node (public, private), it is detected by AutoNAT if empty
+	Reachability string `json:"reachability,omitempty" yaml:"reachability,omitempty"`
+}
+
+// BandwidthConfig contains the configuration of bandwidth accounting
+type BandwidthConfig struct {
+	// Disabled turns off bandwidth accounting
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// MaxPeers is the max number of peers that are reported in bandwidth metrics,
+	// the peers with the most traffic are reported. Metrics per peer are disabled if zero
+	MaxPeers int `json:"maxPeers,omitempty" yaml:"maxPeers,omitempty"`
+}
+
+// LatencyConfig contains the configuration of the latency monitor
+type LatencyConfig struct {
+	// Interval is the interval of pinging connected peers, the latency monitor is disabled if zero
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Concurrency is the max number of concurrent pings, 8 is used if zero
+	Concurrency int `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	// Timeout is the timeout of a single ping, 10s is used if zero
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// GaterConfig contains the configuration of the connection gater
+type GaterConfig struct {
+	// AllowedPeers are the only peers that are allowed to connect, all peers are allowed if empty
+	AllowedPeers []string `json:"allowedPeers,omitempty" yaml:"allowedPeers,omitempty"`
+	// DeniedPeers are peers that are not allowed to connect
+	DeniedPeers []string `json:"deniedPeers,omitempty" yaml:"deniedPeers,omitempty"`
+	// AllowedCIDRs are the only subnets that are allowed for dialing and accepting, all subnets are allowed if empty
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty" yaml:"allowedCIDRs,omitempty"`
+	// DeniedCIDRs are subnets that are not allowed for dialing and accepting
+	DeniedCIDRs []string `json:"deniedCIDRs,omitempty" yaml:"deniedCIDRs,omitempty"`
+	// MaxConnsPerIP is the max number of inbound connections from a single IP, unlimited if zero
+	MaxConnsPerIP int `json:"maxConnsPerIP,omitempty" yaml:"maxConnsPerIP,omitempty"`
+	// MaxConnsPerSubnet is the max number of inbound connections from a single subnet (/24 for ipv4, /64 for ipv6),
+	// unlimited if zero
+	MaxConnsPerSubnet int `json:"maxConnsPerSubnet,omitempty" yaml:"maxConnsPerSubnet,omitempty"`
+}
+
+// ConnManagerConfig contains the configuration of the connection manager
+type ConnManagerConfig struct {
+	// LowWater is the number of connections that the connection manager trims down to
+	LowWater int `json:"lowWater,omitempty" yaml:"lowWater,omitempty"`
+	// HighWater is the number of connections that triggers trimming, the connection manager is disabled if zero
+	HighWater int `json:"highWater,omitempty" yaml:"highWater,omitempty"`
+	// GracePeriod is the time a new connection is kept before it can be trimmed
+	GracePeriod time.Duration `json:"gracePeriod,omitempty" yaml:"gracePeriod,omitempty"`
+	// SilencePeriod is the interval of checking whether connections should be trimmed
+	SilencePeriod time.Duration `json:"silencePeriod,omitempty" yaml:"silencePeriod,omitempty"`
+	// DecayResolution is the interval of decaying peer tags
+	DecayResolution time.Duration `json:"decayResolution,omitempty" yaml:"decayResolution,omitempty"`
+}
+
+// AdminConfig contains the configuration of the admin API
+type AdminConfig struct {
+	// Enabled determines whether the admin API is enabled
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Addr is the address to listen on, either a localhost address (e.g. 127.0.0.1:5001)
+	// or a unix socket (e.g. unix:///var/run/p2p.sock)
+	Addr string `json:"addr,omitempty" yaml:"addr,omitempty"`
 }
 
 // Config contains both dynamic (libp2p components) and static information (json/yaml).
@@ -57,6 +218,15 @@ type Config struct {
 	Routing func(h host.Host) (routing.Routing, error)
 	// PubsubConfigurer enables to configure pubsub components dynamically
 	PubsubConfigurer PubsubConfigurer
+	// BandwidthCounter counts the bandwidth of the host, the facade creates its own counter if nil
+	// unless bandwidth accounting is disabled
+	BandwidthCounter *metrics.BandwidthCounter
+	// ResourceMetrics is used by the resource manager to report allowed and blocked resources,
+	// the facade reports to its own metrics if nil
+	ResourceMetrics rcmgr.MetricsReporter
+	// RelayACL filters the reservations and circuits of the relay service,
+	// an ACL of the allowed peers (see RelayServiceConfig) is used if nil
+	RelayACL relayv2.ACLFilter
 	// Opts is used to inject own options
 	Opts []libp2p.Option
 }
@@ -93,7 +263,8 @@ func (c *Config) MarshalYAML() ([]byte, error) {
 	return yaml.Marshal(&c.StaticConfig)
 }
 
-// Libp2pOptions returns a list of libp2p options for the given config
+// Libp2pOptions returns a list of libp2p options for the given config, it has no side effects.
+// The persistent peerstore of the data directory is not included (see NewPeerstore)
 func (cfg *Config) Libp2pOptions() ([]libp2p.Option, error) {
 	opts := []libp2p.Option{
 		libp2p.ListenAddrStrings(cfg.ListenAddrs...),
@@ -148,13 +319,113 @@ func (cfg *Config) Libp2pOptions() ([]libp2p.Option, error) {
 		opts = append(opts, libp2p.EnableAutoRelay())
 	}
 
+	if cfg.RelayService.Enabled {
+		relayOpt, err := cfg.relayServiceOption()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, relayOpt)
+	}
+
+	natOpts, err := cfg.NAT.libp2pOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, natOpts...)
+
+	if cfg.BandwidthCounter != nil {
+		opts = append(opts, libp2p.BandwidthReporter(cfg.BandwidthCounter))
+	}
+
+	if cfg.ConnManager.HighWater > 0 {
+		cm, err := cfg.ConnManager.connManager()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.ConnectionManager(cm))
+	}
+
+	rm, err := cfg.resourceManager()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, libp2p.ResourceManager(rm))
+
 	opts = append(opts, cfg.Opts...)
 
 	return opts, nil
 }
 
+// ParsePeers parses the given multiaddrs (with /p2p/), addresses of the same peer are merged
+func ParsePeers(addrs []string) ([]peer.AddrInfo, error) {
+	maddrs := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid peer address %s", addr)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	peers, err := peer.AddrInfosFromP2pAddrs(maddrs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid peer address")
+	}
+	return peers, nil
+}
+
+// libp2pOptions returns the libp2p options of NAT traversal
+func (nc NATConfig) libp2pOptions() ([]libp2p.Option, error) {
+	opts := make([]libp2p.Option, 0)
+	if nc.PortMap {
+		opts = append(opts, libp2p.NATPortMap())
+	}
+	if nc.AutoNATService {
+		opts = append(opts, libp2p.EnableNATService())
+	}
+	if nc.HolePunching {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+	switch nc.Reachability {
+	case "":
+	case ReachabilityPublic:
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	case ReachabilityPrivate:
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	default:
+		return nil, errors.Errorf("invalid reachability: %s", nc.Reachability)
+	}
+	return opts, nil
+}
+
+// connManager creates a connection manager from the config
+func (cmc ConnManagerConfig) connManager() (*connmgr.BasicConnMgr, error) {
+	if cmc.LowWater < 0 || cmc.LowWater > cmc.HighWater {
+		return nil, errors.Errorf("invalid connection manager watermarks: low %d, high %d", cmc.LowWater, cmc.HighWater)
+	}
+	opts := make([]connmgr.Option, 0)
+	if cmc.GracePeriod > 0 {
+		opts = append(opts, connmgr.WithGracePeriod(cmc.GracePeriod))
+	}
+	if cmc.SilencePeriod > 0 {
+		opts = append(opts, connmgr.WithSilencePeriod(cmc.SilencePeriod))
+	}
+	if cmc.DecayResolution > 0 {
+		opts = append(opts, connmgr.DecayerConfig(&connmgr.DecayerCfg{Resolution: cmc.DecayResolution}))
+	}
+	cm, err := connmgr.NewConnManager(cmc.LowWater, cmc.HighWater, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create connection manager")
+	}
+	return cm, nil
+}
+
 // Init initialize config
 func (cfg *Config) Init() error {
+	if len(cfg.DataDir) > 0 {
+		if err := cfg.initDataDir(); err != nil {
+			return err
+		}
+	}
 	if err := cfg.initPrivateKey(); err != nil {
 		return errors.Wrap(err, "could not initialize private key")
 	}
@@ -170,16 +441,29 @@ func (cfg *Config) Init() error {
 }
 
 func (cfg *Config) initPrivateKey() error {
-	if cfg.PrivateKey == nil {
-		sk, _, err := crypto.GenerateECDSAKeyPair(crand.Reader)
-		if err != nil {
-			return err
-		}
-		cfg.PrivateKey = sk
+	if cfg.PrivateKey != nil {
+		return nil
+	}
+	var sk crypto.PrivKey
+	var err error
+	if len(cfg.DataDir) > 0 {
+		sk, err = cfg.loadOrCreateKey()
+	} else {
+		sk, err = newPrivateKey()
 	}
+	if err != nil {
+		return err
+	}
+	cfg.PrivateKey = sk
 	return nil
 }
 
+// newPrivateKey generates a new private key
+func newPrivateKey() (crypto.PrivKey, error) {
+	sk, _, err := crypto.GenerateECDSAKeyPair(crand.Reader)
+	return sk, err
+}
+
 // PubsubConfigurer helps to aid in a custom set of configurations for pubsub
 type PubsubConfigurer interface {
 	// Topic enalbes to configure a topic, e.g. score params
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnManagerConfig(t *testing.T) {
+	cm, err := ConnManagerConfig{LowWater: 10, HighWater: 20, GracePeriod: time.Second, DecayResolution: time.Second}.connManager()
+	require.NoError(t, err)
+	require.NoError(t, cm.Close())
+
+	_, err = ConnManagerConfig{LowWater: 20, HighWater: 10}.connManager()
+	require.Error(t, err)
+
+	cfg := &Config{}
+	require.NoError(t, cfg.Init())
+	cfg.ConnManager = ConnManagerConfig{LowWater: -1, HighWater: 10}
+	_, err = cfg.Libp2pOptions()
+	require.Error(t, err)
+}
+
+func TestDataDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	cfg := &Config{StaticConfig: StaticConfig{DataDir: dir}}
+	require.NoError(t, cfg.Init())
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(dataDirMode), info.Mode().Perm())
+
+	// the key is loaded on restart
+	cfg2 := &Config{StaticConfig: StaticConfig{DataDir: dir}}
+	require.NoError(t, cfg2.Init())
+	require.True(t, cfg.PrivateKey.Equals(cfg2.PrivateKey))
+
+	require.NoError(t, os.Chmod(filepath.Join(dir, dataDirKeyFile), 0644))
+	require.Error(t, (&Config{StaticConfig: StaticConfig{DataDir: dir}}).Init())
+
+	// building the options doesn't open the datastore
+	_, err = cfg.Libp2pOptions()
+	require.NoError(t, err)
+	opts, err := cfg.Libp2pOptions()
+	require.NoError(t, err)
+	ps, err := cfg.NewPeerstore()
+	require.NoError(t, err)
+	h, err := libp2p.New(append(opts, libp2p.Peerstore(ps))...)
+	require.NoError(t, err)
+	require.NoError(t, h.Close())
+	// the datastore was closed with the host
+	ps, err = cfg.NewPeerstore()
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+}
+
+func TestParsePeers(t *testing.T) {
+	id := "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"
+	peers, err := ParsePeers([]string{
+		"/ip4/127.0.0.1/tcp/4001/p2p/" + id,
+		"/ip4/127.0.0.1/udp/4001/quic/p2p/" + id,
+	})
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, id, peers[0].ID.String())
+	require.Len(t, peers[0].Addrs, 2)
+
+	_, err = ParsePeers([]string{"/ip4/127.0.0.1/tcp/4001"})
+	require.Error(t, err)
+	_, err = ParsePeers([]string{"invalid"})
+	require.Error(t, err)
+}
+
+func TestResourceManagerConfig(t *testing.T) {
+	rmc := ResourceManagerConfig{
+		System:    ResourceLimit{ConnsInbound: 10, Memory: 1 << 20},
+		Peer:      ResourceLimit{StreamsInbound: 4},
+		Protocols: map[string]ResourceLimit{"/test/1.0.0": {Streams: 2}},
+	}
+	limiter, err := rmc.Limiter()
+	require.NoError(t, err)
+	defaults := rcmgr.NewDefaultLimiter()
+
+	require.Equal(t, 10, limiter.SystemLimits.GetConnLimit(network.DirInbound))
+	require.Equal(t, defaults.SystemLimits.GetConnLimit(network.DirOutbound), limiter.SystemLimits.GetConnLimit(network.DirOutbound))
+	require.Equal(t, int64(1<<20), limiter.SystemLimits.GetMemoryLimit())
+	require.Equal(t, 4, limiter.DefaultPeerLimits.GetStreamLimit(network.DirInbound))
+	require.Equal(t, defaults.TransientLimits, limiter.TransientLimits)
+	require.Equal(t, 2, limiter.GetProtocolLimits("/test/1.0.0").GetStreamTotalLimit())
+	require.Equal(t, defaults.DefaultProtocolLimits, limiter.GetProtocolLimits("/other/1.0.0"))
+
+	_, err = ResourceManagerConfig{Transient: ResourceLimit{FD: -1}}.Limiter()
+	require.Error(t, err)
+}
+
+func TestNATConfig(t *testing.T) {
+	opts, err := NATConfig{AutoNATService: true, HolePunching: true, Reachability: ReachabilityPrivate}.libp2pOptions()
+	require.NoError(t, err)
+	require.Len(t, opts, 3)
+
+	_, err = NATConfig{Reachability: "behind-nat"}.libp2pOptions()
+	require.Error(t, err)
+}
+
+func TestRelayServiceConfig(t *testing.T) {
+	rc := RelayServiceConfig{MaxReservations: 4, CircuitData: 1 << 10}.Resources()
+	require.Equal(t, 4, rc.MaxReservations)
+	require.Equal(t, int64(1<<10), rc.Limit.Data)
+	require.Equal(t, time.Hour, rc.ReservationTTL)
+
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	acl, err := RelayServiceConfig{AllowedPeers: []string{pid.String()}}.ACL()
+	require.NoError(t, err)
+	require.True(t, acl.AllowReserve(pid, nil))
+	require.False(t, acl.AllowReserve("other", nil))
+	acl, err = RelayServiceConfig{}.ACL()
+	require.NoError(t, err)
+	require.True(t, acl.AllowReserve("other", nil))
+
+	_, err = RelayServiceConfig{AllowedPeers: []string{"invalid"}}.ACL()
+	require.Error(t, err)
+}
+
+func TestRendezvousConfig(t *testing.T) {
+	point := "/ip4/127.0.0.1/tcp/4001/p2p/12D3KooWRBhwfeP2Y9CDkFRBAZ1pmxUadH36TKuk3KtKm5XXP8mA"
+	points, err := RendezvousConfig{Points: []string{point}, Namespace: "mynet"}.RendezvousPoints()
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+
+	points, err = RendezvousConfig{}.RendezvousPoints()
+	require.NoError(t, err)
+	require.Len(t, points, 0)
+
+	_, err = RendezvousConfig{Points: []string{point}}.RendezvousPoints()
+	require.Error(t, err)
+	_, err = RendezvousConfig{Points: []string{"/ip4/127.0.0.1/tcp/4001"}, Namespace: "mynet"}.RendezvousPoints()
+	require.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const (
+	// dataDirMode is the file mode of the data directory
+	dataDirMode = 0700
+	// dataDirKeyFile is the name of the private key file in the data directory
+	dataDirKeyFile = "identity.key"
+	// dataDirPeerstore is the name of the peerstore directory in the data directory
+	dataDirPeerstore = "peerstore"
+)
+
+// initDataDir creates the data directory if it does not exist
+func (cfg *Config) initDataDir() error {
+	if err := os.MkdirAll(cfg.DataDir, dataDirMode); err != nil {
+		return errors.Wrap(err, "could not create data directory")
+	}
+	return nil
+}
+
+// loadOrCreateKey loads the private key from the data directory, or creates and saves a new key if it does not exist.
+// Key files that are accessible by other users are rejected.
+func (cfg *Config) loadOrCreateKey() (crypto.PrivKey, error) {
+	path := filepath.Join(cfg.DataDir, dataDirKeyFile)
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.Mode().Perm()&^keyFileMode != 0 {
+			return nil, errors.Errorf("key file %s has insecure permissions %o, expected %o",
+				path, info.Mode().Perm(), keyFileMode)
+		}
+		return LoadPrivateKey(path)
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "could not stat key file")
+	}
+	sk, err := newPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := SavePrivateKey(path, sk); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// NewPeerstore opens a peerstore that is backed by a leveldb datastore in the data directory.
+// The datastore is closed together with the peerstore, which must be closed by the caller
+// (libp2p closes the peerstore of the host once the host is closed).
+func (cfg *Config) NewPeerstore() (peerstore.Peerstore, error) {
+	ds, err := leveldb.NewDatastore(filepath.Join(cfg.DataDir, dataDirPeerstore), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open peerstore datastore")
+	}
+	// the context is used by background goroutines of the peerstore, which are stopped once it is closed
+	ps, err := pstoreds.NewPeerstore(context.Background(), ds, pstoreds.DefaultOpts())
+	if err != nil {
+		_ = ds.Close()
+		return nil, errors.Wrap(err, "could not create peerstore")
+	}
+	cab, ok := peerstore.GetCertifiedAddrBook(ps)
+	if !ok {
+		_ = ps.Close()
+		_ = ds.Close()
+		return nil, errors.New("peerstore is not a certified address book")
+	}
+	return &persistentPeerstore{Peerstore: ps, CertifiedAddrBook: cab, ds: ds}, nil
+}
+
+// persistentPeerstore closes the underlying datastore once the peerstore is closed, it can be closed more than once.
+// It is also a certified address book, as required by libp2p.
+type persistentPeerstore struct {
+	peerstore.Peerstore
+	peerstore.CertifiedAddrBook
+	ds *leveldb.Datastore
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Close implements io.Closer
+func (ps *persistentPeerstore) Close() error {
+	ps.closeOnce.Do(func() {
+		ps.closeErr = multierr.Append(ps.Peerstore.Close(), ps.ds.Close())
+	})
+	return ps.closeErr
+}
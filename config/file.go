@@ -0,0 +1,63 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// keyFileMode is the file mode of private key files
+	keyFileMode = 0600
+)
+
+// LoadStaticConfig reads a static config from the given file, the format (json/yaml) is determined by the file extension
+func LoadStaticConfig(path string) (*StaticConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+	var static StaticConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(raw, &static)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &static)
+	default:
+		return nil, errors.Errorf("unsupported config file: %s", path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse config file")
+	}
+	return &static, nil
+}
+
+// LoadPrivateKey reads a private key from the given file, the key is expected to be marshalled with crypto.MarshalPrivateKey
+func LoadPrivateKey(path string) (crypto.PrivKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read key file")
+	}
+	sk, err := crypto.UnmarshalPrivateKey(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal private key")
+	}
+	return sk, nil
+}
+
+// SavePrivateKey writes the given private key to a file that is readable only by the owner
+func SavePrivateKey(path string, sk crypto.PrivKey) error {
+	raw, err := crypto.MarshalPrivateKey(sk)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal private key")
+	}
+	if err := os.WriteFile(path, raw, keyFileMode); err != nil {
+		return errors.Wrap(err, "could not write key file")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadStaticConfig(t *testing.T) {
+	static, err := LoadStaticConfig("example.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/ip4/0.0.0.0/tcp/8101"}, static.ListenAddrs)
+	require.Equal(t, "mynet/latest", static.UserAgent)
+	require.Equal(t, 60, static.Discovery.RateLimits["mdns"])
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"listenAddrs":["/ip4/127.0.0.1/tcp/0"],"disablePing":true}`), 0600))
+	static, err = LoadStaticConfig(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/ip4/127.0.0.1/tcp/0"}, static.ListenAddrs)
+	require.True(t, static.DisablePing)
+
+	_, err = LoadStaticConfig(filepath.Join(dir, "config.toml"))
+	require.Error(t, err)
+}
+
+func TestPrivateKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	require.NoError(t, SavePrivateKey(path, sk))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(keyFileMode), info.Mode().Perm())
+
+	loaded, err := LoadPrivateKey(path)
+	require.NoError(t, err)
+	require.True(t, sk.Equals(loaded))
+}
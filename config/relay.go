@@ -0,0 +1,95 @@
+package config
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+// RelayServiceConfig contains the configuration of the circuit relay v2 service.
+// The service runs only while the node is publicly reachable (see NATConfig), libp2p defaults are used for zero values
+type RelayServiceConfig struct {
+	// Enabled turns on the relay service
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// ReservationTTL is the duration of a new or refreshed reservation
+	ReservationTTL time.Duration `json:"reservationTTL,omitempty" yaml:"reservationTTL,omitempty"`
+	// MaxReservations is the max number of active reservations
+	MaxReservations int `json:"maxReservations,omitempty" yaml:"maxReservations,omitempty"`
+	// MaxCircuits is the max number of open circuits of each peer
+	MaxCircuits int `json:"maxCircuits,omitempty" yaml:"maxCircuits,omitempty"`
+	// CircuitDuration is the time limit of a circuit before it is reset
+	CircuitDuration time.Duration `json:"circuitDuration,omitempty" yaml:"circuitDuration,omitempty"`
+	// CircuitData is the limit of bytes relayed in each direction of a circuit before it is reset
+	CircuitData int64 `json:"circuitData,omitempty" yaml:"circuitData,omitempty"`
+	// AllowedPeers are the only peers that are allowed to reserve, all peers are allowed if empty
+	AllowedPeers []string `json:"allowedPeers,omitempty" yaml:"allowedPeers,omitempty"`
+}
+
+// Resources returns the resources of the relay service, based on the defaults of libp2p
+func (rsc RelayServiceConfig) Resources() relayv2.Resources {
+	rc := relayv2.DefaultResources()
+	if rsc.ReservationTTL > 0 {
+		rc.ReservationTTL = rsc.ReservationTTL
+	}
+	if rsc.MaxReservations > 0 {
+		rc.MaxReservations = rsc.MaxReservations
+	}
+	if rsc.MaxCircuits > 0 {
+		rc.MaxCircuits = rsc.MaxCircuits
+	}
+	if rsc.CircuitDuration > 0 {
+		rc.Limit.Duration = rsc.CircuitDuration
+	}
+	if rsc.CircuitData > 0 {
+		rc.Limit.Data = rsc.CircuitData
+	}
+	return rc
+}
+
+// ACL returns an ACL that allows reservations only from the allowed peers
+func (rsc RelayServiceConfig) ACL() (relayv2.ACLFilter, error) {
+	acl := &relayACL{allowed: make(map[peer.ID]struct{})}
+	for _, s := range rsc.AllowedPeers {
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid relay allowed peer %s", s)
+		}
+		acl.allowed[pid] = struct{}{}
+	}
+	return acl, nil
+}
+
+// relayACL allows reservations of the allowed peers, or of all peers if empty
+type relayACL struct {
+	allowed map[peer.ID]struct{}
+}
+
+// AllowReserve implements relayv2.ACLFilter
+func (acl *relayACL) AllowReserve(p peer.ID, a ma.Multiaddr) bool {
+	if len(acl.allowed) == 0 {
+		return true
+	}
+	_, ok := acl.allowed[p]
+	return ok
+}
+
+// AllowConnect implements relayv2.ACLFilter
+func (acl *relayACL) AllowConnect(src peer.ID, srcAddr ma.Multiaddr, dest peer.ID) bool {
+	return true
+}
+
+// relayServiceOption returns the libp2p option of the relay service
+func (cfg *Config) relayServiceOption() (libp2p.Option, error) {
+	acl := cfg.RelayACL
+	if acl == nil {
+		var err error
+		if acl, err = cfg.RelayService.ACL(); err != nil {
+			return nil, err
+		}
+	}
+	return libp2p.EnableRelayService(relayv2.WithResources(cfg.RelayService.Resources()), relayv2.WithACL(acl)), nil
+}
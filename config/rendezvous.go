@@ -0,0 +1,50 @@
+package config
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+)
+
+// RendezvousConfig contains the configuration of the rendezvous protocol.
+// The node registers and discovers the namespace in each of the rendezvous points, found peers are connected
+type RendezvousConfig struct {
+	// Server configures the rendezvous server role of the node
+	Server RendezvousServerConfig `json:"server,omitempty" yaml:"server,omitempty"`
+	// Points are the addresses (including peer ID) of the rendezvous points, the client is turned off if empty
+	Points []string `json:"points,omitempty" yaml:"points,omitempty"`
+	// Namespace is registered and discovered in the rendezvous points, required if points are configured
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Interval is the interval of discovering the namespace, 1m is used if zero
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+}
+
+// RendezvousServerConfig contains the configuration of the rendezvous server, defaults are used for zero values
+type RendezvousServerConfig struct {
+	// Enabled turns on the rendezvous server
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// DefaultTTL is the TTL of registrations that did not ask for a TTL, 2h (or MaxTTL if lower) is used if zero
+	DefaultTTL time.Duration `json:"defaultTTL,omitempty" yaml:"defaultTTL,omitempty"`
+	// MaxTTL is the max TTL of registrations, 72h is used if zero
+	MaxTTL time.Duration `json:"maxTTL,omitempty" yaml:"maxTTL,omitempty"`
+	// MaxRegistrations is the max number of registrations in each namespace, 1000 is used if zero
+	MaxRegistrations int `json:"maxRegistrations,omitempty" yaml:"maxRegistrations,omitempty"`
+	// MaxPeerRegistrations is the max number of namespaces each peer is registered in, 100 is used if zero
+	MaxPeerRegistrations int `json:"maxPeerRegistrations,omitempty" yaml:"maxPeerRegistrations,omitempty"`
+}
+
+// RendezvousPoints parses the rendezvous points, it fails if points were configured without a namespace
+func (rc RendezvousConfig) RendezvousPoints() ([]peer.AddrInfo, error) {
+	if len(rc.Points) == 0 {
+		return nil, nil
+	}
+	if len(rc.Namespace) == 0 {
+		return nil, errors.New("rendezvous namespace is required")
+	}
+	points, err := ParsePeers(rc.Points)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse rendezvous points")
+	}
+	return points, nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	"github.com/pkg/errors"
+)
+
+// ResourceManagerConfig contains the limits of the resource manager, libp2p default limits are used for zero values
+type ResourceManagerConfig struct {
+	// System are the limits of the whole node
+	System ResourceLimit `json:"system,omitempty" yaml:"system,omitempty"`
+	// Transient are the limits of resources that are not yet attached to a peer or a protocol
+	Transient ResourceLimit `json:"transient,omitempty" yaml:"transient,omitempty"`
+	// Peer are the limits of each peer
+	Peer ResourceLimit `json:"peer,omitempty" yaml:"peer,omitempty"`
+	// Protocol are the default limits of each protocol
+	Protocol ResourceLimit `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	// Protocols are the limits of specific protocols, by protocol ID
+	Protocols map[string]ResourceLimit `json:"protocols,omitempty" yaml:"protocols,omitempty"`
+}
+
+// ResourceLimit contains the limits of a resource manager scope, the existing limit is kept for zero values
+type ResourceLimit struct {
+	// Streams is the max number of streams
+	Streams int `json:"streams,omitempty" yaml:"streams,omitempty"`
+	// StreamsInbound is the max number of inbound streams
+	StreamsInbound int `json:"streamsInbound,omitempty" yaml:"streamsInbound,omitempty"`
+	// StreamsOutbound is the max number of outbound streams
+	StreamsOutbound int `json:"streamsOutbound,omitempty" yaml:"streamsOutbound,omitempty"`
+	// Conns is the max number of connections
+	Conns int `json:"conns,omitempty" yaml:"conns,omitempty"`
+	// ConnsInbound is the max number of inbound connections
+	ConnsInbound int `json:"connsInbound,omitempty" yaml:"connsInbound,omitempty"`
+	// ConnsOutbound is the max number of outbound connections
+	ConnsOutbound int `json:"connsOutbound,omitempty" yaml:"connsOutbound,omitempty"`
+	// FD is the max number of file descriptors
+	FD int `json:"fd,omitempty" yaml:"fd,omitempty"`
+	// Memory is the max memory in bytes
+	Memory int64 `json:"memory,omitempty" yaml:"memory,omitempty"`
+}
+
+// IsZero returns true if no limit was set
+func (rl ResourceLimit) IsZero() bool {
+	return rl == ResourceLimit{}
+}
+
+// Apply returns a copy of the given limit, overridden by the non zero values of this limit
+func (rl ResourceLimit) Apply(l rcmgr.Limit) rcmgr.Limit {
+	if rl.Streams > 0 || rl.StreamsInbound > 0 || rl.StreamsOutbound > 0 {
+		l = l.WithStreamLimit(orDefault(rl.StreamsInbound, l.GetStreamLimit(network.DirInbound)),
+			orDefault(rl.StreamsOutbound, l.GetStreamLimit(network.DirOutbound)),
+			orDefault(rl.Streams, l.GetStreamTotalLimit()))
+	}
+	if rl.Conns > 0 || rl.ConnsInbound > 0 || rl.ConnsOutbound > 0 {
+		l = l.WithConnLimit(orDefault(rl.ConnsInbound, l.GetConnLimit(network.DirInbound)),
+			orDefault(rl.ConnsOutbound, l.GetConnLimit(network.DirOutbound)),
+			orDefault(rl.Conns, l.GetConnTotalLimit()))
+	}
+	if rl.FD > 0 {
+		l = l.WithFDLimit(rl.FD)
+	}
+	if rl.Memory > 0 {
+		// bounding the memory by the configured value from both sides
+		l = l.WithMemoryLimit(1, rl.Memory, rl.Memory)
+	}
+	return l
+}
+
+func (rl ResourceLimit) validate() error {
+	if rl.Streams < 0 || rl.StreamsInbound < 0 || rl.StreamsOutbound < 0 || rl.Conns < 0 ||
+		rl.ConnsInbound < 0 || rl.ConnsOutbound < 0 || rl.FD < 0 || rl.Memory < 0 {
+		return errors.New("negative limit")
+	}
+	return nil
+}
+
+// Limiter creates a limiter with the default limits of libp2p (including services), overridden by the configured limits
+func (rmc ResourceManagerConfig) Limiter() (*rcmgr.BasicLimiter, error) {
+	scopes := map[string]ResourceLimit{
+		"system":    rmc.System,
+		"transient": rmc.Transient,
+		"peer":      rmc.Peer,
+		"protocol":  rmc.Protocol,
+	}
+	for proto, rl := range rmc.Protocols {
+		scopes["protocol "+proto] = rl
+	}
+	for scope, rl := range scopes {
+		if err := rl.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s resource limit", scope)
+		}
+	}
+
+	limiter := rcmgr.NewDefaultLimiter()
+	libp2p.SetDefaultServiceLimits(limiter)
+	limiter.SystemLimits = rmc.System.Apply(limiter.SystemLimits)
+	limiter.TransientLimits = rmc.Transient.Apply(limiter.TransientLimits)
+	limiter.DefaultPeerLimits = rmc.Peer.Apply(limiter.DefaultPeerLimits)
+	limiter.DefaultProtocolLimits = rmc.Protocol.Apply(limiter.DefaultProtocolLimits)
+	if limiter.ProtocolLimits == nil {
+		limiter.ProtocolLimits = make(map[protocol.ID]rcmgr.Limit)
+	}
+	for proto, rl := range rmc.Protocols {
+		limiter.ProtocolLimits[protocol.ID(proto)] = rl.Apply(limiter.GetProtocolLimits(protocol.ID(proto)))
+	}
+	return limiter, nil
+}
+
+// resourceManager creates a resource manager from the config
+func (cfg *Config) resourceManager() (network.ResourceManager, error) {
+	limiter, err := cfg.ResourceManager.Limiter()
+	if err != nil {
+		return nil, err
+	}
+	opts := make([]rcmgr.Option, 0)
+	if cfg.ResourceMetrics != nil {
+		opts = append(opts, rcmgr.WithMetrics(cfg.ResourceMetrics))
+	}
+	rm, err := rcmgr.NewResourceManager(limiter, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create resource manager")
+	}
+	return rm, nil
+}
+
+func orDefault(val, def int) int {
+	if val > 0 {
+		return val
+	}
+	return def
+}
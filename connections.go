@@ -5,9 +5,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
 	logging "github.com/ipfs/go-log/v2"
 	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -21,56 +25,107 @@ const (
 	backoffHigh = 30 * time.Minute
 	// backoffExponentBase is the base of the backoff exponent
 	backoffExponentBase = 2.0
-	// backoffConnectorCacheSize is the cache size of the backoff connector
-	backoffConnectorCacheSize = 1024
-	// connectorQueueSize is the buffer size of the channel used by the connector
-	connectorQueueSize = 32
+	// knownPeerAddrTTL is the TTL of the addresses of peers that were connected when the facade was closed,
+	// so they are kept in a persistent peerstore and reconnected on the next start
+	knownPeerAddrTTL = 7 * 24 * time.Hour
 )
 
 var (
 	loggerConn = logging.Logger("p2p:conn")
 )
 
-// startConnector starts to receive and handle incoming connect requests
-func (f *facade) startConnector(connectQ ConnectQueue) {
-	buffer := make(chan peer.AddrInfo, connectorQueueSize)
+// Protect implements Facade
+func (f *facade) Protect(pid peer.ID, tag string) {
+	f.host.ConnManager().Protect(pid, tag)
+}
 
-	go func() {
-		ctx, cancel := context.WithCancel(f.ctx)
-		defer cancel()
-		f.backoffConnector.Connect(ctx, buffer)
-	}()
+// Unprotect implements Facade
+func (f *facade) Unprotect(pid peer.ID, tag string) bool {
+	return f.host.ConnManager().Unprotect(pid, tag)
+}
 
+// TagPeer implements Facade
+func (f *facade) TagPeer(pid peer.ID, tag string, value int) {
+	f.host.ConnManager().TagPeer(pid, tag, value)
+}
+
+// UntagPeer implements Facade
+func (f *facade) UntagPeer(pid peer.ID, tag string) {
+	f.host.ConnManager().UntagPeer(pid, tag)
+}
+
+// ScheduleConnect implements Facade
+func (f *facade) ScheduleConnect(pi peer.AddrInfo, priority ConnectPriority) bool {
+	return f.connector.enqueue(pi, priority, PeerSourceScheduled)
+}
+
+// startConnector starts to receive peers from the given queue and schedule connections with the given priority and source
+func (f *facade) startConnector(connectQ ConnectQueue, priority ConnectPriority, source PeerSource) {
 	go func() {
-		ctx, cancel := context.WithCancel(f.ctx)
-		defer cancel()
 		for {
 			select {
 			case pi := <-connectQ:
-				switch f.host.Network().Connectedness(pi.ID) {
-				case libp2pnetwork.CannotConnect, libp2pnetwork.Connected:
-					return
-				default:
-				}
-				loggerConn.Debugf("found new peer %s", pi.String())
-				select {
-				case buffer <- pi:
-				default:
-					// TODO: handle skipped peer
-				}
-			case <-ctx.Done():
+				f.logger.Debugf("found new peer %s", pi.String())
+				f.connector.enqueue(pi, priority, source)
+			case <-f.ctx.Done():
 				return
 			}
 		}
 	}()
 }
 
-func Notiffee(net libp2pnetwork.Network) (*libp2pnetwork.NotifyBundle, func()) {
+// setupPeers resolves the bootstrap peers and parses the static peers from the config,
+// and starts to track the state of static peers
+func (f *facade) setupPeers() error {
+	ctx, cancel := context.WithTimeout(f.ctx, dnsResolveTimeout)
+	defer cancel()
+	bootstrap, err := resolvePeers(ctx, f.resolver, f.cfg.BootstrapPeers, f.logger)
+	if err != nil {
+		return errors.Wrap(err, "could not parse bootstrap peers")
+	}
+	f.setBootstrapPeers(bootstrap)
+	static, err := config.ParsePeers(f.cfg.StaticPeers)
+	if err != nil {
+		return errors.Wrap(err, "could not parse static peers")
+	}
+	f.static = newStaticPeers(f.connector, f.metrics, f.events, f.logger, static)
+	f.host.Network().Notify(f.static.notifiee())
+	return nil
+}
+
+// connectKnownPeers schedules connections to the peers in the peerstore, e.g. peers that were loaded from the data directory
+func (f *facade) connectKnownPeers() {
+	ps := f.host.Peerstore()
+	for _, pid := range ps.PeersWithAddrs() {
+		f.connector.enqueue(ps.PeerInfo(pid), ConnectPriorityNormal, PeerSourcePeerstore)
+	}
+}
+
+// keepKnownPeers extends the TTL of the addresses of connected peers, otherwise they expire shortly after disconnecting
+func (f *facade) keepKnownPeers() {
+	ps := f.host.Peerstore()
+	for _, pid := range f.host.Network().Peers() {
+		ps.SetAddrs(pid, ps.Addrs(pid), knownPeerAddrTTL)
+	}
+}
+
+// Notiffee returns a notify bundle that tracks connected peers with the given gauge, and a function to GC the cache.
+// Connection events are emitted with the given emitter, if not nil.
+func Notiffee(net libp2pnetwork.Network, connected prometheus.Gauge, emitter events.Emitter) (*libp2pnetwork.NotifyBundle, func()) {
+	return notiffee(net, connected, emitter, loggerConn)
+}
+
+// notiffee is Notiffee with the given logger
+func notiffee(net libp2pnetwork.Network, connected prometheus.Gauge, emitter events.Emitter,
+	logger logging.StandardLogger) (*libp2pnetwork.NotifyBundle, func()) {
+	emit := func(evt events.Event) {
+		if emitter != nil {
+			emitter.Emit(evt)
+		}
+	}
 	connectedCache := map[peer.ID]bool{}
 	l := &sync.RWMutex{}
 
-	selfID := net.LocalPeer().String()
-
 	gc := func() {
 		l.Lock()
 		defer l.Unlock()
@@ -85,6 +140,7 @@ func Notiffee(net libp2pnetwork.Network) (*libp2pnetwork.NotifyBundle, func()) {
 		}
 		for _, pid := range toRemove {
 			delete(connectedCache, pid)
+			connected.Dec()
 		}
 	}
 
@@ -96,8 +152,9 @@ func Notiffee(net libp2pnetwork.Network) (*libp2pnetwork.NotifyBundle, func()) {
 			pid := c.RemotePeer()
 			if _, ok := connectedCache[pid]; !ok {
 				connectedCache[pid] = true
-				metricConnections.WithLabelValues(selfID).Inc()
-				loggerConn.Debugf("new connected peer %s", pid.String())
+				connected.Inc()
+				logger.Debugf("new connected peer %s", pid.String())
+				emit(events.PeerConnected{Peer: pid, Direction: c.Stat().Direction})
 			}
 		},
 		DisconnectedF: func(n libp2pnetwork.Network, c libp2pnetwork.Conn) {
@@ -108,10 +165,11 @@ func Notiffee(net libp2pnetwork.Network) (*libp2pnetwork.NotifyBundle, func()) {
 			if n.Connectedness(pid) == libp2pnetwork.Connected {
 				return
 			}
-			if _, ok := connectedCache[pid]; !ok {
+			if _, ok := connectedCache[pid]; ok {
 				delete(connectedCache, pid)
-				metricConnections.WithLabelValues(selfID).Dec()
-				loggerConn.Debugf("disconnected peer %s", pid.String())
+				connected.Dec()
+				logger.Debugf("disconnected peer %s", pid.String())
+				emit(events.PeerDisconnected{Peer: pid})
 			}
 		},
 	}, gc
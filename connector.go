@@ -0,0 +1,314 @@
+package p2pfacade
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ConnectPriority determines the order of connecting peers, higher priorities are connected first
+type ConnectPriority int
+
+const (
+	// ConnectPriorityLow is used for peers that were found with routing discovery (e.g. DHT)
+	ConnectPriorityLow ConnectPriority = iota
+	// ConnectPriorityNormal is used for peers that were found by discoverers (e.g. mdns), sent on the connect queue of Start,
+	// or known from a persistent peerstore (see config.StaticConfig.DataDir)
+	ConnectPriorityNormal
+	// ConnectPriorityHigh is used for static and bootstrap peers
+	ConnectPriorityHigh
+)
+
+const (
+	// connectorMaxPending is the max number of peers that are waiting to be connected
+	connectorMaxPending = 256
+	// connectorMaxAttempts is the number of failed attempts before a peer is dropped
+	connectorMaxAttempts = 5
+	// connectorConcurrency is the max number of concurrent dials
+	connectorConcurrency = 8
+)
+
+// connector status labels
+const (
+	connectorQueued    = "queued"
+	connectorDropped   = "dropped"
+	connectorConnected = "connected"
+	connectorFailed    = "failed"
+)
+
+// pendingPeer is a peer that is waiting to be connected
+type pendingPeer struct {
+	info     peer.AddrInfo
+	priority ConnectPriority
+	source   PeerSource
+	// seq is the order of insertion
+	seq      uint64
+	attempts int
+	next     time.Time
+	dialing  bool
+	backoff  libp2pdisc.BackoffStrategy
+	// persistent peers (static peers) are retried until connected and are never evicted
+	persistent bool
+}
+
+// connector schedules connections to discovered peers.
+// Peers are deduplicated by ID and connected by priority, failed attempts are retried with backoff.
+// The set of pending peers is bounded, once full lower priority peers are evicted.
+type connector struct {
+	ctx     context.Context
+	host    host.Host
+	backoff libp2pdisc.BackoffFactory
+	metrics *metrics
+	peers   *peerTracker
+	logger  logging.StandardLogger
+
+	maxPending  int
+	maxAttempts int
+
+	lock    sync.Mutex
+	pending map[peer.ID]*pendingPeer
+	seq     uint64
+	wakeup  chan struct{}
+}
+
+func newConnector(ctx context.Context, h host.Host, backoff libp2pdisc.BackoffFactory, metrics *metrics, peers *peerTracker,
+	logger logging.StandardLogger) *connector {
+	return &connector{
+		ctx:         ctx,
+		host:        h,
+		backoff:     backoff,
+		metrics:     metrics,
+		peers:       peers,
+		logger:      logger,
+		maxPending:  connectorMaxPending,
+		maxAttempts: connectorMaxAttempts,
+		pending:     make(map[peer.ID]*pendingPeer),
+		wakeup:      make(chan struct{}, 1),
+	}
+}
+
+// enqueue adds the given peer to the pending set, or updates it if it is already pending.
+// The source of the peer is recorded once it was connected, static peers are retried until connected.
+// It returns false if the peer was dropped.
+func (c *connector) enqueue(pi peer.AddrInfo, priority ConnectPriority, source PeerSource) bool {
+	if pi.ID == c.host.ID() || c.host.Network().Connectedness(pi.ID) == libp2pnetwork.Connected {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if p, ok := c.pending[pi.ID]; ok {
+		p.info.Addrs = mergeAddrs(p.info.Addrs, pi.Addrs)
+		if priority > p.priority {
+			p.priority = priority
+			p.source = source
+		}
+		p.persistent = p.persistent || source == PeerSourceStatic
+		c.notify()
+		return true
+	}
+	if len(c.pending) >= c.maxPending {
+		victim := c.evictionCandidate()
+		if victim == nil || victim.priority >= priority {
+			c.logger.Debugf("dropping peer %s: too many pending peers", pi.ID.String())
+			c.metrics.connector.WithLabelValues(connectorDropped).Inc()
+			return false
+		}
+		c.logger.Debugf("evicting peer %s in favor of %s", victim.info.ID.String(), pi.ID.String())
+		c.remove(victim.info.ID)
+		c.metrics.connector.WithLabelValues(connectorDropped).Inc()
+	}
+	c.seq++
+	c.pending[pi.ID] = &pendingPeer{
+		info:     pi,
+		priority: priority,
+		source:   source,
+		seq:      c.seq,
+		next:     time.Now(),
+		backoff:  c.backoff(),
+
+		persistent: source == PeerSourceStatic,
+	}
+	c.metrics.connector.WithLabelValues(connectorQueued).Inc()
+	c.metrics.connectorPending.Set(float64(len(c.pending)))
+	c.notify()
+	return true
+}
+
+// run connects pending peers until the context is done
+func (c *connector) run() {
+	sem := make(chan struct{}, connectorConcurrency)
+	for {
+		select {
+		case sem <- struct{}{}:
+		case <-c.ctx.Done():
+			return
+		}
+		pi, ok := c.waitNext()
+		if !ok {
+			return
+		}
+		go func() {
+			defer func() {
+				<-sem
+			}()
+			c.dial(pi)
+		}()
+	}
+}
+
+// waitNext waits until a pending peer is ready to be connected, returns false if the context is done
+func (c *connector) waitNext() (peer.AddrInfo, bool) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		pi, wait, ok := c.next()
+		if ok {
+			return pi, true
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-c.wakeup:
+		case <-timer.C:
+		case <-c.ctx.Done():
+			return peer.AddrInfo{}, false
+		}
+	}
+}
+
+// next returns the peer with the highest priority that is ready to be connected and marks it as dialing.
+// If no peer is ready, it returns the time until the next peer is ready, or zero if there are no peers.
+func (c *connector) next() (peer.AddrInfo, time.Duration, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	var selected, earliest *pendingPeer
+	for _, p := range c.pending {
+		if p.dialing {
+			continue
+		}
+		if p.next.After(now) {
+			if earliest == nil || p.next.Before(earliest.next) {
+				earliest = p
+			}
+			continue
+		}
+		if selected == nil || p.priority > selected.priority ||
+			(p.priority == selected.priority && p.seq < selected.seq) {
+			selected = p
+		}
+	}
+	if selected != nil {
+		selected.dialing = true
+		return selected.info, 0, true
+	}
+	if earliest != nil {
+		return peer.AddrInfo{}, earliest.next.Sub(now), false
+	}
+	return peer.AddrInfo{}, 0, false
+}
+
+// dial connects the given peer, and reschedules it in case of failure
+func (c *connector) dial(pi peer.AddrInfo) {
+	var err error
+	if c.host.Network().Connectedness(pi.ID) != libp2pnetwork.Connected {
+		ctx, cancel := context.WithTimeout(c.ctx, connectTimeout)
+		err = c.host.Connect(ctx, pi)
+		cancel()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	defer c.notify()
+
+	p, ok := c.pending[pi.ID]
+	if !ok {
+		return
+	}
+	if err == nil {
+		c.peers.setSource(pi.ID, p.source)
+		c.remove(pi.ID)
+		c.metrics.connector.WithLabelValues(connectorConnected).Inc()
+		return
+	}
+	c.logger.Debugf("could not connect to peer %s: %s", pi.ID.String(), err.Error())
+	c.metrics.connector.WithLabelValues(connectorFailed).Inc()
+	p.attempts++
+	p.dialing = false
+	if (p.attempts >= c.maxAttempts && !p.persistent) || c.ctx.Err() != nil {
+		c.remove(pi.ID)
+		c.metrics.connector.WithLabelValues(connectorDropped).Inc()
+		return
+	}
+	p.next = time.Now().Add(p.backoff.Delay())
+}
+
+// evictionCandidate returns the pending peer that should be evicted first:
+// the lowest priority, then the most failed attempts, then the newest.
+// Peers that are being dialed and persistent peers are not evicted.
+// The caller must hold the lock.
+func (c *connector) evictionCandidate() *pendingPeer {
+	var victim *pendingPeer
+	for _, p := range c.pending {
+		if p.dialing || p.persistent {
+			continue
+		}
+		switch {
+		case victim == nil, p.priority < victim.priority:
+			victim = p
+		case p.priority == victim.priority &&
+			(p.attempts > victim.attempts || (p.attempts == victim.attempts && p.seq > victim.seq)):
+			victim = p
+		}
+	}
+	return victim
+}
+
+// remove removes the given peer from the pending set, the caller must hold the lock
+func (c *connector) remove(pid peer.ID) {
+	delete(c.pending, pid)
+	c.metrics.connectorPending.Set(float64(len(c.pending)))
+}
+
+// notify wakes up the connector loop
+func (c *connector) notify() {
+	select {
+	case c.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// mergeAddrs returns the union of the given addresses
+func mergeAddrs(addrs, others []ma.Multiaddr) []ma.Multiaddr {
+	merged := append([]ma.Multiaddr{}, addrs...)
+	for _, addr := range others {
+		found := false
+		for _, existing := range addrs {
+			if addr.Equal(existing) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, addr)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,144 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New()
+	defer func() {
+		require.NoError(t, mn.Close())
+	}()
+	hosts := make([]host.Host, 5)
+	for i := range hosts {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		hosts[i] = h
+	}
+	peers := newPeerTracker()
+	hosts[0].Network().Notify(peers.notifiee())
+	newTestConnector := func() *connector {
+		return newConnector(ctx, hosts[0], libp2pdisc.NewFixedBackoff(10*time.Millisecond), newMetrics(commons.MetricsOpts{}), peers, loggerConn)
+	}
+	status := func(c *connector, s string) float64 {
+		return testutil.ToFloat64(c.metrics.connector.WithLabelValues(s))
+	}
+
+	t.Run("priority", func(t *testing.T) {
+		c := newTestConnector()
+		require.False(t, c.enqueue(*host.InfoFromHost(hosts[0]), ConnectPriorityHigh, PeerSourceScheduled))
+		require.True(t, c.enqueue(peer.AddrInfo{ID: hosts[1].ID()}, ConnectPriorityLow, PeerSourceScheduled))
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[2]), ConnectPriorityHigh, PeerSourceScheduled))
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[3]), ConnectPriorityNormal, PeerSourceScheduled))
+		// duplicates are merged, and the higher priority is kept
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[1]), ConnectPriorityHigh, PeerSourceScheduled))
+		require.True(t, c.enqueue(peer.AddrInfo{ID: hosts[1].ID()}, ConnectPriorityLow, PeerSourceScheduled))
+		require.Len(t, c.pending, 3)
+		require.Equal(t, 3.0, status(c, connectorQueued))
+
+		for _, expected := range []host.Host{hosts[1], hosts[2], hosts[3]} {
+			pi, _, ok := c.next()
+			require.True(t, ok)
+			require.Equal(t, expected.ID(), pi.ID)
+			require.Equal(t, expected.Addrs(), pi.Addrs)
+		}
+		_, wait, ok := c.next()
+		require.False(t, ok)
+		require.Zero(t, wait)
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		c := newTestConnector()
+		c.maxPending = 2
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[1]), ConnectPriorityLow, PeerSourceScheduled))
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[2]), ConnectPriorityLow, PeerSourceScheduled))
+		// the newest low priority peer is evicted
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[3]), ConnectPriorityNormal, PeerSourceScheduled))
+		require.False(t, c.enqueue(*host.InfoFromHost(hosts[4]), ConnectPriorityLow, PeerSourceScheduled))
+		require.Len(t, c.pending, 2)
+		require.Contains(t, c.pending, hosts[1].ID())
+		require.Contains(t, c.pending, hosts[3].ID())
+		require.Equal(t, 2.0, status(c, connectorDropped))
+		require.Equal(t, 2.0, testutil.ToFloat64(c.metrics.connectorPending))
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		c := newTestConnector()
+		c.maxAttempts = 1000
+		go c.run()
+
+		// not linked, so connecting fails until linked
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[1]), ConnectPriorityNormal, PeerSourceScheduled))
+		require.Eventually(t, func() bool {
+			return status(c, connectorFailed) >= 2
+		}, 5*time.Second, 5*time.Millisecond)
+		_, err := mn.LinkPeers(hosts[0].ID(), hosts[1].ID())
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return status(c, connectorConnected) == 1
+		}, 5*time.Second, 5*time.Millisecond)
+		require.Equal(t, hosts[1].ID(), hosts[0].Network().Peers()[0])
+		require.Zero(t, testutil.ToFloat64(c.metrics.connectorPending))
+		// connected peers are skipped
+		require.False(t, c.enqueue(*host.InfoFromHost(hosts[1]), ConnectPriorityNormal, PeerSourceScheduled))
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		c := newTestConnector()
+		c.maxAttempts = 2
+		go c.run()
+
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[2]), ConnectPriorityNormal, PeerSourceScheduled))
+		require.Eventually(t, func() bool {
+			return status(c, connectorDropped) == 1
+		}, 5*time.Second, 5*time.Millisecond)
+		require.Equal(t, 2.0, status(c, connectorFailed))
+		require.Zero(t, testutil.ToFloat64(c.metrics.connectorPending))
+	})
+
+	t.Run("static", func(t *testing.T) {
+		c := newTestConnector()
+		c.maxPending = 1
+		c.maxAttempts = 1
+		go c.run()
+
+		// static peers are not evicted, and retried until connected
+		require.True(t, c.enqueue(*host.InfoFromHost(hosts[3]), ConnectPriorityLow, PeerSourceStatic))
+		require.False(t, c.enqueue(*host.InfoFromHost(hosts[4]), ConnectPriorityHigh, PeerSourceScheduled))
+		require.Eventually(t, func() bool {
+			return status(c, connectorFailed) >= 3
+		}, 5*time.Second, 5*time.Millisecond)
+		require.Equal(t, 1.0, status(c, connectorDropped))
+		_, err := mn.LinkPeers(hosts[0].ID(), hosts[3].ID())
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return status(c, connectorConnected) == 1
+		}, 5*time.Second, 5*time.Millisecond)
+		_, source := c.peers.get(hosts[3].ID())
+		require.Equal(t, PeerSourceStatic, source)
+
+		// the source of a peer that was disconnected before it was recorded is not kept
+		require.NoError(t, hosts[0].Network().ClosePeer(hosts[3].ID()))
+		require.Eventually(t, func() bool {
+			connectedAt, _ := c.peers.get(hosts[3].ID())
+			return connectedAt.IsZero()
+		}, 5*time.Second, 5*time.Millisecond)
+		c.peers.setSource(hosts[3].ID(), PeerSourceStatic)
+		_, source = c.peers.get(hosts[3].ID())
+		require.Equal(t, PeerSourceUnknown, source)
+	})
+}
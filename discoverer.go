@@ -0,0 +1,413 @@
+package p2pfacade
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/rendezvous"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// discoveryDefaultDedupTTL is the duration in which a found peer is ignored if not configured
+	discoveryDefaultDedupTTL = time.Minute
+	// discoveryDefaultInterval is the interval of namespace lookups if not configured
+	discoveryDefaultInterval = time.Minute
+	// discoveryDefaultLimit is the max number of peers to find in each namespace lookup
+	discoveryDefaultLimit = 32
+	// discoveryRateWindow is the window of the per source rate limits
+	discoveryRateWindow = time.Minute
+	// mdnsFoundBuffer is the number of peers found by mdns that are buffered until they are handled
+	mdnsFoundBuffer = 32
+)
+
+const (
+	// discoveryStatusAccepted is used for found peers that were passed to the handler
+	discoveryStatusAccepted = "accepted"
+	// discoveryStatusDuplicate is used for found peers that were already found recently
+	discoveryStatusDuplicate = "duplicate"
+	// discoveryStatusLimited is used for found peers that were dropped by the rate limit of the source
+	discoveryStatusLimited = "limited"
+)
+
+// Discoverer is a source of peers to connect, discoverers are composed with CompositeDiscoverer (see WithDiscoverers)
+type Discoverer interface {
+	// Source returns the source of the found peers (see PeerInfo.Source)
+	Source() PeerSource
+	// Discover sends the found peers on the given channel until the context is done or no more peers can be found.
+	// The channel must not be used once Discover returned
+	Discover(ctx context.Context, found chan<- peer.AddrInfo) error
+}
+
+// PrioritizedDiscoverer is a Discoverer that sets the connect priority of the peers it finds.
+// Peers of other discoverers are connected with ConnectPriorityLow if found with routing (PeerSourceRouting),
+// or with ConnectPriorityNormal otherwise
+type PrioritizedDiscoverer interface {
+	Discoverer
+	// Priority returns the connect priority of the found peers
+	Priority() ConnectPriority
+}
+
+// discoveryPriority returns the connect priority of the peers found by the given discoverer
+func discoveryPriority(d Discoverer) ConnectPriority {
+	if pd, ok := d.(PrioritizedDiscoverer); ok {
+		return pd.Priority()
+	}
+	if d.Source() == PeerSourceRouting {
+		return ConnectPriorityLow
+	}
+	return ConnectPriorityNormal
+}
+
+// DiscoveryHandler handles a peer that was found by the given source
+type DiscoveryHandler func(pi peer.AddrInfo, source PeerSource)
+
+// CompositeDiscoverer runs several discoverers concurrently,
+// found peers are deduplicated by peer ID and rate limited per source before they are handled
+type CompositeDiscoverer struct {
+	dedupTTL time.Duration
+	sources  []*discoverySource
+
+	lock sync.Mutex
+	seen map[peer.ID]time.Time
+
+	// observe is called with the status of each found peer, if not nil
+	observe func(source PeerSource, status string)
+	// logger is replaced by the facade
+	logger logging.StandardLogger
+}
+
+// discoverySource is a discoverer with its rate limit and the connect priority of its peers
+type discoverySource struct {
+	Discoverer
+	limiter  *rateLimiter
+	priority ConnectPriority
+}
+
+// NewCompositeDiscoverer creates a new composite discoverer,
+// a peer that was found is ignored for the given duration (1m if zero) by all sources
+func NewCompositeDiscoverer(dedupTTL time.Duration) *CompositeDiscoverer {
+	if dedupTTL <= 0 {
+		dedupTTL = discoveryDefaultDedupTTL
+	}
+	return &CompositeDiscoverer{
+		dedupTTL: dedupTTL,
+		seen:     make(map[peer.ID]time.Time),
+		logger:   loggerConn,
+	}
+}
+
+// Add adds a discoverer that accepts up to the given number of peers per minute, or unlimited if zero.
+// Discoverers must be added before Run
+func (cd *CompositeDiscoverer) Add(d Discoverer, rateLimit int) {
+	cd.sources = append(cd.sources, &discoverySource{
+		Discoverer: d,
+		limiter:    newRateLimiter(rateLimit, discoveryRateWindow),
+		priority:   discoveryPriority(d),
+	})
+}
+
+// Run runs all the discoverers and passes the found peers to the given handler, until the context is done.
+// It returns once all discoverers returned
+func (cd *CompositeDiscoverer) Run(ctx context.Context, handler DiscoveryHandler) {
+	cd.run(ctx, func(pi peer.AddrInfo, src *discoverySource) {
+		handler(pi, src.Source())
+	})
+}
+
+// run is Run with a handler that is called with the discoverer that found the peer
+func (cd *CompositeDiscoverer) run(ctx context.Context, handler func(pi peer.AddrInfo, src *discoverySource)) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go cd.gc(ctx)
+
+	var wg sync.WaitGroup
+	for _, src := range cd.sources {
+		wg.Add(1)
+		go func(src *discoverySource) {
+			defer wg.Done()
+			cd.runSource(ctx, src, handler)
+		}(src)
+	}
+	wg.Wait()
+}
+
+// runSource runs the given discoverer, and handles the found peers until it returned
+func (cd *CompositeDiscoverer) runSource(ctx context.Context, src *discoverySource,
+	handler func(pi peer.AddrInfo, src *discoverySource)) {
+	found := make(chan peer.AddrInfo)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for pi := range found {
+			if cd.accept(pi.ID, src) {
+				handler(pi, src)
+			}
+		}
+	}()
+	if err := src.Discover(ctx, found); err != nil && ctx.Err() == nil {
+		cd.logger.Warnf("discovery of source %s failed: %v", src.Source(), err)
+	}
+	close(found)
+	<-done
+}
+
+// accept returns whether a peer found by the given source should be handled,
+// peers that were found recently or that exceed the rate limit of the source are dropped
+func (cd *CompositeDiscoverer) accept(pid peer.ID, src *discoverySource) bool {
+	cd.lock.Lock()
+	defer cd.lock.Unlock()
+
+	now := time.Now()
+	status := discoveryStatusAccepted
+	if expire, ok := cd.seen[pid]; ok && expire.After(now) {
+		status = discoveryStatusDuplicate
+	} else if !src.limiter.allow(now) {
+		status = discoveryStatusLimited
+	} else {
+		cd.seen[pid] = now.Add(cd.dedupTTL)
+	}
+	if cd.observe != nil {
+		cd.observe(src.Source(), status)
+	}
+	return status == discoveryStatusAccepted
+}
+
+// gc removes expired peers every dedup TTL, until the context is done
+func (cd *CompositeDiscoverer) gc(ctx context.Context) {
+	ticker := time.NewTicker(cd.dedupTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cd.lock.Lock()
+			now := time.Now()
+			for pid, expire := range cd.seen {
+				if !expire.After(now) {
+					delete(cd.seen, pid)
+				}
+			}
+			cd.lock.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rateLimiter allows up to limit events in each window, all events are allowed if limit is zero
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window}
+}
+
+// allow returns whether another event is allowed in the current window, it is not thread safe
+func (rl *rateLimiter) allow(now time.Time) bool {
+	if rl.limit <= 0 {
+		return true
+	}
+	if now.Sub(rl.start) >= rl.window {
+		rl.start = now
+		rl.count = 0
+	}
+	if rl.count >= rl.limit {
+		return false
+	}
+	rl.count++
+	return true
+}
+
+// NewStaticDiscoverer creates a discoverer that finds the given peers once
+func NewStaticDiscoverer(source PeerSource, peers []peer.AddrInfo) Discoverer {
+	return &staticDiscoverer{source: source, peers: peers}
+}
+
+type staticDiscoverer struct {
+	source PeerSource
+	peers  []peer.AddrInfo
+}
+
+// Source implements Discoverer
+func (sd *staticDiscoverer) Source() PeerSource {
+	return sd.source
+}
+
+// Discover implements Discoverer
+func (sd *staticDiscoverer) Discover(ctx context.Context, found chan<- peer.AddrInfo) error {
+	for _, pi := range sd.peers {
+		select {
+		case found <- pi:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// NewMdnsDiscoverer creates a discoverer that finds peers in the local network with mdns
+func NewMdnsDiscoverer(h host.Host, serviceTag string) Discoverer {
+	return &mdnsDiscoverer{host: h, serviceTag: serviceTag}
+}
+
+type mdnsDiscoverer struct {
+	host       host.Host
+	serviceTag string
+}
+
+// Source implements Discoverer
+func (md *mdnsDiscoverer) Source() PeerSource {
+	return PeerSourceMdns
+}
+
+// Discover implements Discoverer, the mdns service runs until the context is done
+func (md *mdnsDiscoverer) Discover(ctx context.Context, found chan<- peer.AddrInfo) error {
+	// the notifee never sends on the found channel, so it is safe to use after the service was closed
+	mdnsq := make(ConnectQueue, mdnsFoundBuffer)
+	svc := mdns.NewMdnsService(md.host, md.serviceTag, &mdnsDisc{ctx, mdnsq})
+	if err := svc.Start(); err != nil {
+		return errors.Wrap(err, "could not start mdns")
+	}
+	defer func() {
+		if err := svc.Close(); err != nil {
+			loggerConn.Debugf("could not close mdns: %v", err)
+		}
+	}()
+	for {
+		select {
+		case pi := <-mdnsq:
+			select {
+			case found <- pi:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NewNamespaceDiscoverer creates a discoverer that advertises the given namespace,
+// and looks up peers of the namespace every interval (1m if zero) with the given discovery
+func NewNamespaceDiscoverer(source PeerSource, disc discovery.Discovery, ns string, interval time.Duration) Discoverer {
+	if interval <= 0 {
+		interval = discoveryDefaultInterval
+	}
+	return &namespaceDiscoverer{source: source, disc: disc, ns: ns, interval: interval}
+}
+
+type namespaceDiscoverer struct {
+	source   PeerSource
+	disc     discovery.Discovery
+	ns       string
+	interval time.Duration
+}
+
+// Source implements Discoverer
+func (nd *namespaceDiscoverer) Source() PeerSource {
+	return nd.source
+}
+
+// Discover implements Discoverer, the namespace is advertised until the context is done
+func (nd *namespaceDiscoverer) Discover(ctx context.Context, found chan<- peer.AddrInfo) error {
+	libp2pdisc.Advertise(ctx, nd.disc, nd.ns)
+	ticker := time.NewTicker(nd.interval)
+	defer ticker.Stop()
+	for {
+		nd.findPeers(ctx, found)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// findPeers looks up peers of the namespace and sends the ones with addresses on the found channel
+func (nd *namespaceDiscoverer) findPeers(ctx context.Context, found chan<- peer.AddrInfo) {
+	ctx, cancel := context.WithTimeout(ctx, nd.interval)
+	defer cancel()
+
+	peers, err := nd.disc.FindPeers(ctx, nd.ns, discovery.Limit(discoveryDefaultLimit))
+	if err != nil {
+		loggerConn.Debugf("could not find peers of namespace %s: %v", nd.ns, err)
+		return
+	}
+	for pi := range peers {
+		if len(pi.Addrs) == 0 {
+			continue
+		}
+		select {
+		case found <- pi:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// setupDiscovery creates the composite discoverer of the facade with mdns, routing, rendezvous points
+// and the given discoverers
+func (f *facade) setupDiscovery(discoverers []Discoverer) error {
+	dcfg := f.cfg.Discovery
+	f.discovery = NewCompositeDiscoverer(dcfg.DedupTTL)
+	f.discovery.logger = f.logger
+	f.discovery.observe = func(source PeerSource, status string) {
+		f.metrics.discovered.WithLabelValues(string(source), status).Inc()
+	}
+	add := func(d Discoverer) {
+		f.discovery.Add(d, dcfg.RateLimits[string(d.Source())])
+	}
+	if len(f.cfg.MdnsServiceTag) > 0 {
+		add(NewMdnsDiscoverer(f.host, f.cfg.MdnsServiceTag))
+		f.logger.Info("using mdns discovery, tag ", f.cfg.MdnsServiceTag)
+	}
+	if f.disc != nil && len(dcfg.Namespace) > 0 {
+		add(NewNamespaceDiscoverer(PeerSourceRouting, f.disc, dcfg.Namespace, dcfg.Interval))
+		f.logger.Info("using routing discovery, namespace ", dcfg.Namespace)
+	}
+	rcfg := f.cfg.Rendezvous
+	points, err := rcfg.RendezvousPoints()
+	if err != nil {
+		return err
+	}
+	for _, point := range points {
+		client := rendezvous.NewClient(f.StreamConfig(), point)
+		add(NewNamespaceDiscoverer(PeerSourceRendezvous, client, rcfg.Namespace, rcfg.Interval))
+		f.logger.Info("using rendezvous point ", point.ID.String(), ", namespace ", rcfg.Namespace)
+	}
+	for _, d := range discoverers {
+		add(d)
+	}
+	return nil
+}
+
+// startDiscovery runs the discoverers in the background,
+// found peers are scheduled for connection with the priority of the discoverer that found them
+func (f *facade) startDiscovery() {
+	ctx, cancel := context.WithCancel(f.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.discovery.run(ctx, func(pi peer.AddrInfo, src *discoverySource) {
+			f.logger.Debugf("found new peer %s from %s", pi.String(), src.Source())
+			f.connector.enqueue(pi, src.priority, src.Source())
+		})
+	}()
+	f.stopDiscovery = func() {
+		cancel()
+		<-done
+	}
+}
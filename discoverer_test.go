@@ -0,0 +1,183 @@
+package p2pfacade
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositeDiscoverer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers := []peer.AddrInfo{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	cd := NewCompositeDiscoverer(time.Hour)
+	cd.Add(NewStaticDiscoverer("first", peers[:2]), 0)
+	// b is found by both sources, if the second source was faster its rate limit drops c
+	cd.Add(NewStaticDiscoverer("second", peers[1:]), 1)
+
+	var lock sync.Mutex
+	statuses := make(map[string]int)
+	cd.observe = func(source PeerSource, status string) {
+		lock.Lock()
+		defer lock.Unlock()
+		statuses[status]++
+	}
+	found := make(map[peer.ID]PeerSource)
+	cd.Run(ctx, func(pi peer.AddrInfo, source PeerSource) {
+		lock.Lock()
+		defer lock.Unlock()
+		_, ok := found[pi.ID]
+		require.False(t, ok, "peer %s was handled twice", pi.ID)
+		found[pi.ID] = source
+	})
+
+	require.Equal(t, PeerSource("first"), found["a"])
+	require.Equal(t, 4, statuses[discoveryStatusAccepted]+statuses[discoveryStatusDuplicate]+statuses[discoveryStatusLimited])
+	require.Equal(t, len(found), statuses[discoveryStatusAccepted])
+	require.Equal(t, 1, statuses[discoveryStatusDuplicate])
+	require.Len(t, found, 3-statuses[discoveryStatusLimited])
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(2, time.Minute)
+	require.True(t, rl.allow(now))
+	require.True(t, rl.allow(now.Add(time.Second)))
+	require.False(t, rl.allow(now.Add(2*time.Second)))
+	require.True(t, rl.allow(now.Add(time.Minute)))
+
+	require.True(t, newRateLimiter(0, time.Minute).allow(now))
+}
+
+func TestDiscoverers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	disc := newMemDiscovery()
+	nodes := make([]Facade, 2)
+	for i := range nodes {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		opts := []Option{WithConfig(&config.Config{}), WithHost(h), WithMetricsRegistry(nil),
+			WithDiscoverers(NewNamespaceDiscoverer(PeerSourceRouting, disc.forHost(h), "test.discoverers", 100*time.Millisecond))}
+		if i == 0 {
+			opts = append(opts, WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+		}
+		f, err := New(ctx, opts...)
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+	a, b := nodes[0], nodes[1]
+
+	// either a or b dials the other, the source is set on the dialing side
+	discovered := func(f, other Facade) bool {
+		pi, ok := f.PeerInfo(other.Host().ID())
+		return ok && pi.Source == PeerSourceRouting
+	}
+	require.Eventually(t, func() bool {
+		return discovered(a, b) || discovered(b, a)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return gatherValue(t, reg, "test_discovered_peers", "status", discoveryStatusDuplicate) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.GreaterOrEqual(t, gatherValue(t, reg, "test_discovered_peers", "source", string(PeerSourceRouting)), float64(2))
+}
+
+func TestDiscoveryPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids := make([]peer.ID, 3)
+	for i := range ids {
+		ids[i] = randPeerID(t)
+	}
+	routingPeers := []peer.AddrInfo{{ID: ids[0]}, {ID: ids[1]}}
+	mdnsPeers := []peer.AddrInfo{{ID: ids[2]}}
+	cfg := &config.Config{}
+	cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil),
+		WithDiscoverers(NewStaticDiscoverer(PeerSourceRouting, routingPeers), NewStaticDiscoverer(PeerSourceMdns, mdnsPeers)))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	fc := f.(*facade)
+	require.Len(t, fc.discovery.sources, 2)
+	require.Equal(t, ConnectPriorityLow, fc.discovery.sources[0].priority)
+	require.Equal(t, ConnectPriorityNormal, fc.discovery.sources[1].priority)
+
+	// the connector is not running, so the found peers stay pending
+	fc.connector.maxPending = 2
+	fc.startDiscovery()
+	// the discoverers run concurrently, once full a routing peer is evicted or dropped in favor of the mdns peer
+	require.Eventually(t, func() bool {
+		fc.connector.lock.Lock()
+		defer fc.connector.lock.Unlock()
+
+		_, ok := fc.connector.pending[ids[2]]
+		return ok && len(fc.connector.pending) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1.0, testutil.ToFloat64(fc.metrics.connector.WithLabelValues(connectorDropped)))
+}
+
+func TestDiscoveryPrioritySameSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	low, high := randPeerID(t), randPeerID(t)
+	cfg := &config.Config{}
+	cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil), WithDiscoverers(
+		NewStaticDiscoverer(PeerSourceRouting, []peer.AddrInfo{{ID: low}}),
+		&prioritizedDiscoverer{
+			Discoverer: NewStaticDiscoverer(PeerSourceRouting, []peer.AddrInfo{{ID: high}}),
+			priority:   ConnectPriorityHigh,
+		},
+	))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	fc := f.(*facade)
+
+	// the connector is not running, so the found peers stay pending
+	fc.startDiscovery()
+	// each peer is pending with the priority of the discoverer that found it
+	require.Eventually(t, func() bool {
+		fc.connector.lock.Lock()
+		defer fc.connector.lock.Unlock()
+
+		return len(fc.connector.pending) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	fc.connector.lock.Lock()
+	defer fc.connector.lock.Unlock()
+	require.Equal(t, ConnectPriorityLow, fc.connector.pending[low].priority)
+	require.Equal(t, ConnectPriorityHigh, fc.connector.pending[high].priority)
+}
+
+// prioritizedDiscoverer is a discoverer with the given connect priority
+type prioritizedDiscoverer struct {
+	Discoverer
+	priority ConnectPriority
+}
+
+// Priority implements PrioritizedDiscoverer
+func (pd *prioritizedDiscoverer) Priority() ConnectPriority {
+	return pd.priority
+}
@@ -0,0 +1,235 @@
+package p2pfacade
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dnsDefaultResolveInterval is the interval of re-resolving DNS multiaddrs if not configured
+	dnsDefaultResolveInterval = 10 * time.Minute
+	// dnsResolveTimeout is the timeout of resolving a list of multiaddrs
+	dnsResolveTimeout = 30 * time.Second
+	// dnsMaxDepth is the max depth of nested dnsaddr records
+	dnsMaxDepth = 4
+)
+
+// Resolver resolves multiaddrs with DNS components (dns, dns4, dns6, dnsaddr), it is implemented by madns.Resolver.
+// madns.DefaultResolver is used by default (see WithResolver)
+type Resolver interface {
+	Resolve(ctx context.Context, maddr ma.Multiaddr) ([]ma.Multiaddr, error)
+}
+
+var _ Resolver = (*madns.Resolver)(nil)
+
+// ResolvePeers parses the given multiaddrs (with /p2p/) into peers, DNS multiaddrs are resolved with the given resolver.
+// DNS multiaddrs that could not be resolved are skipped so they can be resolved later, resolved multiaddrs without
+// a peer ID are skipped as well. It fails only if a multiaddr is invalid
+func ResolvePeers(ctx context.Context, r Resolver, addrs []string) ([]peer.AddrInfo, error) {
+	return resolvePeers(ctx, r, addrs, loggerConn)
+}
+
+// resolvePeers is ResolvePeers with the given logger
+func resolvePeers(ctx context.Context, r Resolver, addrs []string, logger logging.StandardLogger) ([]peer.AddrInfo, error) {
+	maddrs := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid peer address %s", addr)
+		}
+		if !madns.Matches(maddr) {
+			maddrs = append(maddrs, maddr)
+			continue
+		}
+		resolved, err := resolveAddr(ctx, r, maddr, dnsMaxDepth)
+		if err != nil {
+			logger.Warnf("could not resolve %s: %v", addr, err)
+			continue
+		}
+		for _, rmaddr := range resolved {
+			if _, id := peer.SplitAddr(rmaddr); len(id) == 0 {
+				logger.Debugf("skipping resolved address %s of %s without peer ID", rmaddr.String(), addr)
+				continue
+			}
+			maddrs = append(maddrs, rmaddr)
+		}
+	}
+	peers, err := peer.AddrInfosFromP2pAddrs(maddrs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid peer address")
+	}
+	return peers, nil
+}
+
+// resolveAddr resolves the given multiaddr, including nested dnsaddr records up to the given depth
+func resolveAddr(ctx context.Context, r Resolver, maddr ma.Multiaddr, depth int) ([]ma.Multiaddr, error) {
+	if !madns.Matches(maddr) {
+		return []ma.Multiaddr{maddr}, nil
+	}
+	if depth == 0 {
+		return nil, errors.New("too many nested dnsaddr records")
+	}
+	resolved, err := r.Resolve(ctx, maddr)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ma.Multiaddr, 0, len(resolved))
+	for _, rmaddr := range resolved {
+		nested, err := resolveAddr(ctx, r, rmaddr, depth-1)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, nested...)
+	}
+	return results, nil
+}
+
+// hasDNSAddrs returns whether any of the given multiaddrs has a DNS component
+func hasDNSAddrs(addrs []string) bool {
+	for _, addr := range addrs {
+		if maddr, err := ma.NewMultiaddr(addr); err == nil && madns.Matches(maddr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDNSPeers re-resolves the bootstrap peers and relayers every interval, until the context is done.
+// Bootstrap peers are scheduled for connection, the addresses of relayers are updated in the peerstore
+// and new relayers are pushed to autorelay
+func (f *facade) resolveDNSPeers() {
+	interval := f.cfg.DNSResolveInterval
+	if interval <= 0 {
+		interval = dnsDefaultResolveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	if f.relays != nil {
+		f.relays.push(f.ctx, f.relays.initial)
+	}
+	for {
+		select {
+		case <-ticker.C:
+			if hasDNSAddrs(f.cfg.BootstrapPeers) {
+				f.refreshBootstrap()
+			}
+			if hasDNSAddrs(f.cfg.Relayers) {
+				f.refreshRelayers(interval)
+			}
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshBootstrap resolves the bootstrap peers, and schedules connections to the resolved peers
+func (f *facade) refreshBootstrap() {
+	ctx, cancel := context.WithTimeout(f.ctx, dnsResolveTimeout)
+	defer cancel()
+
+	bootstrap, err := resolvePeers(ctx, f.resolver, f.cfg.BootstrapPeers, f.logger)
+	if err != nil {
+		f.logger.Warnf("could not resolve bootstrap peers: %v", err)
+		return
+	}
+	f.setBootstrapPeers(bootstrap)
+	for _, pi := range bootstrap {
+		f.connector.enqueue(pi, ConnectPriorityHigh, PeerSourceBootstrap)
+	}
+}
+
+// refreshRelayers resolves the relayers, and adds the resolved addresses to the peerstore until the next resolution.
+// Relayers that were not resolved before are pushed to autorelay, if enabled
+func (f *facade) refreshRelayers(interval time.Duration) {
+	ctx, cancel := context.WithTimeout(f.ctx, dnsResolveTimeout)
+	defer cancel()
+
+	relays, err := resolvePeers(ctx, f.resolver, f.cfg.Relayers, f.logger)
+	if err != nil {
+		f.logger.Warnf("could not resolve relayers: %v", err)
+		return
+	}
+	ps := f.host.Peerstore()
+	for _, pi := range relays {
+		ps.AddAddrs(pi.ID, pi.Addrs, 2*interval)
+	}
+	if f.relays != nil {
+		f.relays.push(ctx, relays)
+	}
+}
+
+// relaySource is the peer source of autorelay when the relayers have DNS multiaddrs,
+// so relayers that are resolved only later (or with new peers) are used as well.
+// It replaces the static relays of the config, which are parsed once as is
+type relaySource struct {
+	peers chan peer.AddrInfo
+	// initial are the relayers that were resolved when the facade was created
+	initial []peer.AddrInfo
+	// pushed are the relayers that were pushed to autorelay, it is used only by the goroutine of resolveDNSPeers
+	pushed map[peer.ID]bool
+}
+
+// newRelaySource creates a relay source with the given resolved relayers,
+// the returned option enables autorelay with the relay source and must be given after the options of the config
+func newRelaySource(relays []peer.AddrInfo) (*relaySource, libp2p.Option) {
+	rs := &relaySource{
+		peers:   make(chan peer.AddrInfo),
+		initial: relays,
+		pushed:  make(map[peer.ID]bool),
+	}
+	// as done for static relays, autorelay waits for all the resolved relayers before making reservations
+	minCandidates := len(relays)
+	if minCandidates == 0 {
+		minCandidates = 1
+	}
+	return rs, libp2p.EnableAutoRelay(autorelay.WithPeerSource(rs.peers), autorelay.WithMinCandidates(minCandidates))
+}
+
+// push sends the given relayers that were not pushed before to autorelay, until the context is done
+func (rs *relaySource) push(ctx context.Context, relays []peer.AddrInfo) {
+	for _, pi := range relays {
+		if rs.pushed[pi.ID] {
+			continue
+		}
+		select {
+		case rs.peers <- pi:
+			rs.pushed[pi.ID] = true
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// bootstrapPeers returns the current bootstrap peers
+func (f *facade) bootstrapPeers() []peer.AddrInfo {
+	f.bootstrapLock.RLock()
+	defer f.bootstrapLock.RUnlock()
+
+	return f.bootstrap
+}
+
+// setBootstrapPeers replaces the bootstrap peers
+func (f *facade) setBootstrapPeers(bootstrap []peer.AddrInfo) {
+	f.bootstrapLock.Lock()
+	defer f.bootstrapLock.Unlock()
+
+	f.bootstrap = bootstrap
+}
+
+// isBootstrapPeer returns whether the given peer is one of the current bootstrap peers
+func (f *facade) isBootstrapPeer(pid peer.ID) bool {
+	for _, pi := range f.bootstrapPeers() {
+		if pi.ID == pid {
+			return true
+		}
+	}
+	return false
+}
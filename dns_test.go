@@ -0,0 +1,198 @@
+package p2pfacade
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids := make([]peer.ID, 3)
+	for i := range ids {
+		ids[i] = randPeerID(t)
+	}
+	dns := newFakeDNS()
+	dns.setTXT("_dnsaddr.bootstrap.test",
+		fmt.Sprintf("dnsaddr=/ip4/10.0.0.1/tcp/4001/p2p/%s", ids[0]),
+		fmt.Sprintf("dnsaddr=/ip4/10.0.0.1/udp/4001/quic/p2p/%s", ids[0]),
+		"dnsaddr=/dnsaddr/nested.test",
+		// addresses without peer ID are skipped
+		"dnsaddr=/ip4/10.0.0.4/tcp/4001")
+	dns.setTXT("_dnsaddr.nested.test", fmt.Sprintf("dnsaddr=/ip4/10.0.0.2/tcp/4001/p2p/%s", ids[1]))
+	dns.setIP("relay.test", "10.0.0.3")
+	r, err := madns.NewResolver(madns.WithDefaultResolver(dns))
+	require.NoError(t, err)
+
+	peers, err := ResolvePeers(ctx, r, []string{
+		"/dnsaddr/bootstrap.test",
+		fmt.Sprintf("/dns4/relay.test/tcp/4002/p2p/%s", ids[2]),
+		// unknown names are skipped
+		"/dnsaddr/unknown.test",
+		fmt.Sprintf("/ip4/10.0.0.5/tcp/4001/p2p/%s", ids[2]),
+	})
+	require.NoError(t, err)
+	addrs := make(map[peer.ID][]string)
+	for _, pi := range peers {
+		for _, addr := range pi.Addrs {
+			addrs[pi.ID] = append(addrs[pi.ID], addr.String())
+		}
+	}
+	require.Equal(t, map[peer.ID][]string{
+		ids[0]: {"/ip4/10.0.0.1/tcp/4001", "/ip4/10.0.0.1/udp/4001/quic"},
+		ids[1]: {"/ip4/10.0.0.2/tcp/4001"},
+		ids[2]: {"/ip4/10.0.0.3/tcp/4002", "/ip4/10.0.0.5/tcp/4001"},
+	}, addrs)
+
+	_, err = ResolvePeers(ctx, r, []string{"/ip4/10.0.0.5/tcp/4001"})
+	require.Error(t, err, "missing peer ID")
+	_, err = ResolvePeers(ctx, r, []string{"bootstrap.test"})
+	require.Error(t, err, "invalid multiaddr")
+}
+
+func TestDNSBootstrap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func(bootstrap []string, opts ...Option) Facade {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.BootstrapPeers = bootstrap
+		cfg.DNSResolveInterval = 100 * time.Millisecond
+		f, err := New(ctx, append([]Option{WithConfig(cfg), WithMetricsRegistry(nil)}, opts...)...)
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+		return f
+	}
+	dnsaddr := func(f Facade) string {
+		return fmt.Sprintf("dnsaddr=%s/p2p/%s", f.Host().Addrs()[0], f.Host().ID())
+	}
+	bootstrapped := func(f, seed Facade) bool {
+		pi, ok := f.PeerInfo(seed.Host().ID())
+		return ok && pi.Source == PeerSourceBootstrap
+	}
+
+	seeds := []Facade{newNode(nil), newNode(nil)}
+	dns := newFakeDNS()
+	dns.setTXT("_dnsaddr.seed.test", dnsaddr(seeds[0]))
+	r, err := madns.NewResolver(madns.WithDefaultResolver(dns))
+	require.NoError(t, err)
+
+	f := newNode([]string{"/dnsaddr/seed.test"}, WithResolver(r))
+	require.Eventually(t, func() bool {
+		return bootstrapped(f, seeds[0])
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the record was updated, the new seed is found once re-resolved
+	dns.setTXT("_dnsaddr.seed.test", dnsaddr(seeds[1]))
+	require.Eventually(t, func() bool {
+		return bootstrapped(f, seeds[1])
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, f.(*facade).isBootstrapPeer(seeds[1].Host().ID()))
+	require.False(t, f.(*facade).isBootstrapPeer(seeds[0].Host().ID()))
+}
+
+func randPeerID(t *testing.T) peer.ID {
+	_, pk, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	return pid
+}
+
+// fakeDNS is an in-process DNS that can be updated while used
+type fakeDNS struct {
+	lock sync.Mutex
+	ip   map[string][]net.IPAddr
+	txt  map[string][]string
+}
+
+var _ madns.BasicResolver = (*fakeDNS)(nil)
+
+func newFakeDNS() *fakeDNS {
+	return &fakeDNS{ip: make(map[string][]net.IPAddr), txt: make(map[string][]string)}
+}
+
+func (fd *fakeDNS) setIP(name string, ips ...string) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	fd.ip[name] = addrs
+}
+
+func (fd *fakeDNS) setTXT(name string, records ...string) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+
+	fd.txt[name] = records
+}
+
+func (fd *fakeDNS) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+
+	return fd.ip[name], nil
+}
+
+func (fd *fakeDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+
+	return fd.txt[name], nil
+}
+
+func TestDNSRelayers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func(cfg *config.Config, opts ...Option) Facade {
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.DNSResolveInterval = 100 * time.Millisecond
+		f, err := New(ctx, append([]Option{WithConfig(cfg), WithMetricsRegistry(nil)}, opts...)...)
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+		return f
+	}
+	relayCfg := &config.Config{}
+	relayCfg.NAT.Reachability = config.ReachabilityPublic
+	relayCfg.RelayService.Enabled = true
+	relay := newNode(relayCfg)
+
+	dns := newFakeDNS()
+	r, err := madns.NewResolver(madns.WithDefaultResolver(dns))
+	require.NoError(t, err)
+	cfg := &config.Config{}
+	cfg.Relayers = []string{"/dnsaddr/relay.test"}
+	cfg.EnableAutoRelay = true
+	cfg.NAT.Reachability = config.ReachabilityPrivate
+	// the relayer is not resolved when the node is created
+	f := newNode(cfg, WithResolver(r))
+	require.Empty(t, f.(*facade).relays.initial)
+
+	// the relayer is pushed to autorelay once resolved, and a reservation is made
+	dns.setTXT("_dnsaddr.relay.test", fmt.Sprintf("dnsaddr=%s/p2p/%s", relay.Host().Addrs()[0], relay.Host().ID()))
+	require.Eventually(t, func() bool {
+		return relay.(*facade).relay.count() == 1
+	}, 10*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,81 @@
+package p2pfacade
+
+import (
+	"github.com/amirylm/libp2p-facade/events"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+// SubscribeEvents implements Facade
+func (f *facade) SubscribeEvents(bufferSize int, evtTypes ...events.Event) (<-chan events.Event, events.CancelFunc) {
+	return f.events.Subscribe(bufferSize, evtTypes...)
+}
+
+// handleHostEvents translates the relevant events of the host event bus into facade events
+func (f *facade) handleHostEvents() error {
+	sub, err := f.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtLocalAddressesUpdated),
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not subscribe to host events")
+	}
+
+	go func() {
+		defer func() {
+			_ = sub.Close()
+		}()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch evt := e.(type) {
+				case event.EvtPeerIdentificationCompleted:
+					f.events.Emit(f.peerIdentified(evt.Peer))
+				case event.EvtLocalReachabilityChanged:
+					f.nat.setReachability(evt.Reachability)
+					if f.relay != nil && evt.Reachability == network.ReachabilityPrivate {
+						// the relay service is stopped once the node is privately reachable
+						f.relay.reset()
+					}
+					f.reportReachability(evt.Reachability)
+					f.events.Emit(events.ReachabilityChanged{Reachability: evt.Reachability, ObservedAddrs: f.nat.observedAddrs()})
+				case event.EvtLocalAddressesUpdated:
+					addrs := make([]ma.Multiaddr, 0, len(evt.Current))
+					for _, addr := range evt.Current {
+						addrs = append(addrs, addr.Address)
+					}
+					f.events.Emit(events.AddrsUpdated{Addrs: addrs, ObservedAddrs: f.nat.observedAddrs()})
+				}
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// peerIdentified creates a PeerIdentified event with the information from the peerstore
+func (f *facade) peerIdentified(pid peer.ID) events.PeerIdentified {
+	evt := events.PeerIdentified{Peer: pid}
+	if av, err := f.host.Peerstore().Get(pid, "AgentVersion"); err == nil {
+		evt.AgentVersion, _ = av.(string)
+	}
+	if protocols, err := f.host.Peerstore().GetProtocols(pid); err == nil {
+		evt.Protocols = protocols
+	}
+	return evt
+}
+
+// streamHandlerError emits a StreamHandlerError event
+func (f *facade) streamHandlerError(protocol protocol.ID, peerID peer.ID, err error) {
+	f.events.Emit(events.StreamHandlerError{Protocol: protocol, Peer: peerID, Err: err})
+}
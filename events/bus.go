@@ -0,0 +1,114 @@
+package events
+
+import (
+	"reflect"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const (
+	defaultBufferSize = 32
+)
+
+var (
+	logger = logging.Logger("p2p:events")
+)
+
+// Emitter emits events
+type Emitter interface {
+	Emit(evt Event)
+}
+
+// CancelFunc cancels a subscription
+type CancelFunc func()
+
+// Bus is an in-memory event bus, it delivers events to subscribers without blocking the emitter.
+// Events are dropped for subscribers that don't keep up, i.e. their buffer is full.
+type Bus struct {
+	lock   sync.RWMutex
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+type subscription struct {
+	out   chan Event
+	types map[reflect.Type]struct{}
+}
+
+// NewBus creates a new event bus
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*subscription]struct{}),
+	}
+}
+
+// Subscribe returns a channel of events of the given types, or all events if no type was provided.
+// Types are specified with values, e.g. Subscribe(0, PeerConnected{}, PeerDisconnected{}).
+// The returned channel is closed once the subscription was cancelled or the bus was closed.
+func (b *Bus) Subscribe(bufferSize int, evtTypes ...Event) (<-chan Event, CancelFunc) {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	sub := &subscription{
+		out:   make(chan Event, bufferSize),
+		types: make(map[reflect.Type]struct{}),
+	}
+	for _, evtType := range evtTypes {
+		sub.types[reflect.TypeOf(evtType)] = struct{}{}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		close(sub.out)
+		return sub.out, func() {}
+	}
+	b.subs[sub] = struct{}{}
+
+	return sub.out, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub.out)
+		}
+	}
+}
+
+// Emit implements Emitter
+func (b *Bus) Emit(evt Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	evtType := reflect.TypeOf(evt)
+	for sub := range b.subs {
+		if len(sub.types) > 0 {
+			if _, ok := sub.types[evtType]; !ok {
+				continue
+			}
+		}
+		select {
+		case sub.out <- evt:
+		default:
+			logger.Debugf("dropping event %s: subscriber queue is full", evtType.String())
+		}
+	}
+}
+
+// Close closes all the subscriptions, the following events will be ignored
+func (b *Bus) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.out)
+	}
+	b.subs = nil
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	all, cancelAll := bus.Subscribe(4)
+	defer cancelAll()
+	topics, cancelTopics := bus.Subscribe(4, TopicJoined{}, TopicPeerJoined{})
+
+	bus.Emit(PeerConnected{Peer: peer.ID("a")})
+	bus.Emit(TopicJoined{Topic: "test"})
+	bus.Emit(TopicPeerLeft{Topic: "test", Peer: peer.ID("a")})
+
+	require.Equal(t, PeerConnected{Peer: peer.ID("a")}, <-all)
+	require.Equal(t, TopicJoined{Topic: "test"}, <-all)
+	require.Equal(t, TopicPeerLeft{Topic: "test", Peer: peer.ID("a")}, <-all)
+	require.Equal(t, TopicJoined{Topic: "test"}, <-topics)
+	require.Len(t, topics, 0)
+
+	// cancelled subscription is closed and won't get events
+	cancelTopics()
+	cancelTopics()
+	_, ok := <-topics
+	require.False(t, ok)
+	bus.Emit(TopicJoined{Topic: "test"})
+	require.Equal(t, TopicJoined{Topic: "test"}, <-all)
+
+	// events are dropped when the subscriber doesn't keep up
+	for i := 0; i < 10; i++ {
+		bus.Emit(PeerDisconnected{Peer: peer.ID("a")})
+	}
+	require.Len(t, all, 4)
+
+	bus.Close()
+	for range all {
+	}
+	bus.Emit(PeerDisconnected{Peer: peer.ID("a")})
+	closed, cancelClosed := bus.Subscribe(1)
+	defer cancelClosed()
+	_, ok = <-closed
+	require.False(t, ok)
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Event is a facade event, one of the types in this package
+type Event interface{}
+
+// PeerConnected is emitted when a new peer was connected
+type PeerConnected struct {
+	Peer      peer.ID
+	Direction network.Direction
+}
+
+// PeerDisconnected is emitted when the last connection to some peer was closed
+type PeerDisconnected struct {
+	Peer peer.ID
+}
+
+// PeerIdentified is emitted when identify protocol was completed with some peer
+type PeerIdentified struct {
+	Peer         peer.ID
+	AgentVersion string
+	Protocols    []string
+}
+
+// TopicJoined is emitted when we joined a topic
+type TopicJoined struct {
+	Topic string
+}
+
+// TopicLeft is emitted when we left a topic
+type TopicLeft struct {
+	Topic string
+}
+
+// TopicPeerJoined is emitted when a peer joined a topic that we joined
+type TopicPeerJoined struct {
+	Topic string
+	Peer  peer.ID
+}
+
+// TopicPeerLeft is emitted when a peer left a topic that we joined
+type TopicPeerLeft struct {
+	Topic string
+	Peer  peer.ID
+}
+
+// SubscriptionDropped is emitted when a subscription was terminated without unsubscribing
+type SubscriptionDropped struct {
+	Topic string
+	Err   error
+}
+
+// StreamHandlerError is emitted when handling an incoming stream failed
+type StreamHandlerError struct {
+	Protocol protocol.ID
+	Peer     peer.ID
+	Err      error
+}
+
+// StaticPeerConnected is emitted when a static peer was connected
+type StaticPeerConnected struct {
+	Peer peer.ID
+}
+
+// StaticPeerDisconnected is emitted when a static peer was disconnected, it will be reconnected with backoff
+type StaticPeerDisconnected struct {
+	Peer peer.ID
+}
+
+// ReachabilityChanged is emitted when the reachability of the node was changed
+type ReachabilityChanged struct {
+	Reachability  network.Reachability
+	ObservedAddrs []ma.Multiaddr
+}
+
+// AddrsUpdated is emitted when the addresses that the node advertises were changed
+type AddrsUpdated struct {
+	Addrs         []ma.Multiaddr
+	ObservedAddrs []ma.Multiaddr
+}
@@ -0,0 +1,172 @@
+package p2pfacade
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/amirylm/libp2p-facade/streams"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nodes := make([]Facade, 2)
+	for i := range nodes {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.UserAgent = "test/events"
+		f, err := New(ctx, WithConfig(cfg), WithPubsubConfigurer(pubsub.NewNilConfigurer()), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	a, b := nodes[0], nodes[1]
+	defer func() {
+		require.NoError(t, a.Close())
+	}()
+
+	evts, cancelEvts := a.SubscribeEvents(64)
+	defer cancelEvts()
+	next := func(evtType events.Event) events.Event {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case evt := <-evts:
+				if evtType == nil || sameType(evt, evtType) {
+					return evt
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting for event %T", evtType)
+				return nil
+			}
+		}
+	}
+
+	require.NoError(t, a.Host().Connect(ctx, *host.InfoFromHost(b.Host())))
+	connected := next(events.PeerConnected{}).(events.PeerConnected)
+	require.Equal(t, b.Host().ID(), connected.Peer)
+	require.Equal(t, network.DirOutbound, connected.Direction)
+	identified := next(events.PeerIdentified{}).(events.PeerIdentified)
+	require.Equal(t, b.Host().ID(), identified.Peer)
+	require.Equal(t, "test/events", identified.AgentVersion)
+	require.NotEmpty(t, identified.Protocols)
+
+	topicName := "test.events"
+	require.NoError(t, a.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	require.Equal(t, events.TopicJoined{Topic: topicName}, next(events.TopicJoined{}))
+	require.NoError(t, b.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	require.Equal(t, events.TopicPeerJoined{Topic: topicName, Peer: b.Host().ID()}, next(events.TopicPeerJoined{}))
+
+	// subscription that was cancelled without unsubscribing
+	a.GetSubscription(topicName).Cancel()
+	dropped := next(events.SubscriptionDropped{}).(events.SubscriptionDropped)
+	require.Equal(t, topicName, dropped.Topic)
+	require.Nil(t, a.GetSubscription(topicName))
+
+	pid := protocol.ID("/test/events")
+	a.Host().SetStreamHandler(pid, func(s network.Stream) {
+		_, _, done, err := streams.HandleStreamWithConfig(s, a.StreamConfig())
+		require.Error(t, err)
+		_ = done()
+	})
+	s, err := b.Host().NewStream(ctx, a.Host().ID(), pid)
+	require.NoError(t, err)
+	require.NoError(t, s.Reset())
+	handlerErr := next(events.StreamHandlerError{}).(events.StreamHandlerError)
+	require.Equal(t, pid, handlerErr.Protocol)
+	require.Equal(t, b.Host().ID(), handlerErr.Peer)
+	require.Error(t, handlerErr.Err)
+
+	require.NoError(t, b.Close())
+	expected := map[events.Event]bool{
+		events.TopicPeerLeft{Topic: topicName, Peer: b.Host().ID()}: true,
+		events.PeerDisconnected{Peer: b.Host().ID()}:                true,
+	}
+	for len(expected) > 0 {
+		switch evt := next(nil).(type) {
+		case events.TopicPeerLeft, events.PeerDisconnected:
+			delete(expected, evt)
+		}
+	}
+}
+
+func sameType(a, b interface{}) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
+
+func TestUnSubscribeDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &config.Config{}
+	cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	f, err := New(ctx, WithConfig(cfg), WithPubsubConfigurer(pubsub.NewNilConfigurer()), WithMetricsRegistry(nil))
+	require.NoError(t, err)
+	require.NoError(t, f.Start(nil))
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+
+	evts, cancelEvts := f.SubscribeEvents(16, events.SubscriptionDropped{}, events.TopicLeft{}, events.TopicJoined{})
+	defer cancelEvts()
+	next := func() events.Event {
+		select {
+		case evt := <-evts:
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for event")
+			return nil
+		}
+	}
+
+	topicName := "test.events.dropped"
+	require.NoError(t, f.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	require.Equal(t, events.TopicJoined{Topic: topicName}, next())
+	f.GetSubscription(topicName).Cancel()
+	require.IsType(t, events.SubscriptionDropped{}, next())
+	// the topic is still joined until unsubscribing
+	require.Equal(t, []string{topicName}, f.Topics())
+
+	// goroutines of closed facades of other tests might still be exiting
+	require.Eventually(t, func() bool {
+		return topicHandlerGoroutines() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, f.UnSubscribe(topicName))
+	require.Equal(t, events.TopicLeft{Topic: topicName}, next())
+	require.Empty(t, f.Topics())
+	require.Nil(t, f.GetTopic(topicName))
+	// the goroutine of the topic events exits once the topic was left
+	require.Eventually(t, func() bool {
+		return topicHandlerGoroutines() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the topic can be joined again
+	require.NoError(t, f.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	require.Equal(t, events.TopicJoined{Topic: topicName}, next())
+	require.NotNil(t, f.GetSubscription(topicName))
+}
+
+// topicHandlerGoroutines returns the number of goroutines that handle topic events
+func topicHandlerGoroutines() int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return strings.Count(string(buf[:n]), "pubsub.(*pubsubService).handleTopicEvents.func")
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
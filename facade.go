@@ -4,21 +4,38 @@ import (
 	"context"
 	"io"
 	"math/rand"
+	"net"
+	"sync"
 	"time"
 
+	"github.com/amirylm/libp2p-facade/admin"
+	"github.com/amirylm/libp2p-facade/commons"
 	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
+	"github.com/amirylm/libp2p-facade/gater"
 	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/amirylm/libp2p-facade/rendezvous"
+	"github.com/amirylm/libp2p-facade/streams"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/discovery"
 	"github.com/libp2p/go-libp2p-core/host"
+	libp2pmetrics "github.com/libp2p/go-libp2p-core/metrics"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/libp2p/go-libp2p-core/routing"
 	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
-	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	routedhost "github.com/libp2p/go-libp2p/p2p/host/routed"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 )
 
 var (
 	logger = logging.Logger("p2p:facade")
+	// ErrClosed is returned when trying to use a facade that was closed
+	ErrClosed = errors.New("facade is closed")
 )
 
 type ConnectQueue chan peer.AddrInfo
@@ -27,7 +44,52 @@ type ConnectQueue chan peer.AddrInfo
 type Facade interface {
 	Start(connectQ ConnectQueue) error
 	Host() host.Host
+	// Routing returns the routing of the host, or nil if routing was not configured
+	Routing() routing.Routing
+	// Discovery returns a discovery service on top of routing, or nil if routing was not configured
+	Discovery() discovery.Discovery
+	// StreamConfig returns a config for making and handling streams with the host and metrics of the facade
+	StreamConfig() streams.StreamConfig
+	// SetStreamHandler sets a handler for the given protocol on the host, with the given resource limits of the protocol.
+	// The limits of the resource manager are kept for zero values (see config.ResourceManagerConfig)
+	SetStreamHandler(proto protocol.ID, handler libp2pnetwork.StreamHandler, limit config.ResourceLimit) error
 	pubsub.PubsubService
+	// MeshPeers returns the peers in our mesh of the given topic (gossipsub)
+	MeshPeers(topicName string) []peer.ID
+	// Peers returns the information on all connected peers
+	Peers() []PeerInfo
+	// PeerInfo returns the information on the given peer, or false if the peer is not connected
+	PeerInfo(pid peer.ID) (PeerInfo, bool)
+	// Reachability returns the current reachability of the node, as well as its advertised and observed addresses
+	Reachability() ReachabilityInfo
+	// Latency returns the moving average of the latency to the given peer, or zero if it was not measured.
+	// Connected peers are measured periodically when the latency monitor is enabled (see config.LatencyConfig)
+	Latency(pid peer.ID) time.Duration
+	// ScheduleConnect schedules a connection to the given peer with the given priority,
+	// it returns false if the peer is already connected or was dropped because too many peers are pending
+	ScheduleConnect(pi peer.AddrInfo, priority ConnectPriority) bool
+	// ExchangePeers requests peers that match the given filter from the given connected peer (see config.PeerExchangeConfig),
+	// only peer records that were signed by their peers are used, the returned peers are scheduled for connection
+	ExchangePeers(pid peer.ID, filter PeerExchangeFilter) ([]peer.AddrInfo, error)
+	// Gater returns the connection gater, which can be used to update the allow/deny lists in runtime.
+	// It returns nil if an existing host was provided (see WithHost).
+	Gater() *gater.Gater
+	// AdminAddr returns the address of the admin API, or nil if it is disabled
+	AdminAddr() net.Addr
+	// Protect protects the connections to the given peer from being trimmed by the connection manager,
+	// until Unprotect is called with the same tag
+	Protect(pid peer.ID, tag string)
+	// Unprotect removes the given protection tag, and returns whether the peer is still protected by other tags
+	Unprotect(pid peer.ID, tag string) bool
+	// TagPeer sets a tag on the given peer, peers with lower tag values are trimmed first
+	TagPeer(pid peer.ID, tag string, value int)
+	// UntagPeer removes the given tag from the peer
+	UntagPeer(pid peer.ID, tag string)
+	// SubscribeEvents returns a channel of facade events of the given types (see events package),
+	// or all events if no type was provided. The returned function cancels the subscription.
+	SubscribeEvents(bufferSize int, evtTypes ...events.Event) (<-chan events.Event, events.CancelFunc)
+	// Done returns a channel that is closed once the facade was closed
+	Done() <-chan struct{}
 	io.Closer
 }
 
@@ -36,7 +98,7 @@ func StartNodes(ctx context.Context, cfgs []*config.Config) ([]Facade, error) {
 	nodes := []Facade{}
 
 	for _, cfg := range cfgs {
-		f, err := New(ctx, cfg)
+		f, err := New(ctx, WithConfig(cfg))
 		if err != nil {
 			return nodes, err
 		}
@@ -51,105 +113,410 @@ func StartNodes(ctx context.Context, cfgs []*config.Config) ([]Facade, error) {
 	return nodes, nil
 }
 
-// New creates a new p2p facade with the given config,
-// if options were provided they will be used instead.
-func New(ctx context.Context, cfg *config.Config, opts ...libp2p.Option) (Facade, error) {
-	if len(opts) == 0 {
-		err := cfg.Init()
-		if err != nil {
+// New creates a new p2p facade with the given options
+func New(pctx context.Context, opts ...Option) (_ Facade, err error) {
+	o, err := applyOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// the collectors of the facade are unregistered once closed, or if it could not be created
+	var registerer *commons.Registerer
+	if o.metrics.Registerer != nil {
+		registerer = commons.NewRegisterer(o.metrics.Registerer)
+		o.metrics.Registerer = registerer
+		defer func() {
+			if err != nil {
+				registerer.UnregisterAll()
+			}
+		}()
+	}
+	cfg := o.cfg
+	h := o.host
+	var g *gater.Gater
+	var relay *relayReservations
+	var relays *relaySource
+	var bandwidth *libp2pmetrics.BandwidthCounter
+	if h == nil {
+		if g, err = gater.New(cfg.Gater, gater.NewMetrics(o.metrics)); err != nil {
+			return nil, errors.Wrap(err, "could not create connection gater")
+		}
+		if err := cfg.Init(); err != nil {
 			return nil, err
 		}
-		opts, err = cfg.Libp2pOptions()
-		if err != nil {
+		// the host is created from a copy of the config with the components of this facade,
+		// so the given config is not changed and can be reused (e.g. restarts)
+		hcfg := *cfg
+		if hcfg.ResourceMetrics == nil {
+			hcfg.ResourceMetrics = newResourceMetrics(o.metrics)
+		}
+		libp2pOpts := append([]libp2p.Option{libp2p.ConnectionGater(g)}, o.libp2pOpts...)
+		if len(cfg.DataDir) > 0 {
+			var ps peerstore.Peerstore
+			if ps, err = cfg.NewPeerstore(); err != nil {
+				return nil, err
+			}
+			// the peerstore is closed with the host, or here if the facade could not be created
+			// so the datastore doesn't keep the data directory locked
+			defer func() {
+				if err != nil {
+					_ = ps.Close()
+				}
+			}()
+			libp2pOpts = append(libp2pOpts, libp2p.Peerstore(ps))
+		}
+		if cfg.EnableAutoRelay && hasDNSAddrs(cfg.Relayers) {
+			rctx, rcancel := context.WithTimeout(pctx, dnsResolveTimeout)
+			resolved, err := resolvePeers(rctx, o.resolver, cfg.Relayers, o.logger)
+			rcancel()
+			if err != nil {
+				return nil, errors.Wrap(err, "could not resolve relayers")
+			}
+			var relaysOpt libp2p.Option
+			relays, relaysOpt = newRelaySource(resolved)
+			libp2pOpts = append(libp2pOpts, relaysOpt)
+		}
+		if cfg.RelayService.Enabled {
+			var relayOpt libp2p.Option
+			if relay, relayOpt, err = setupRelayService(o.metrics, cfg); err != nil {
+				return nil, errors.Wrap(err, "could not setup relay service")
+			}
+			libp2pOpts = append(libp2pOpts, relayOpt)
+		}
+		bandwidth = cfg.BandwidthCounter
+		if bandwidth == nil && !cfg.Bandwidth.Disabled {
+			// each facade has its own counter, so facades of the same config don't share their stats
+			bandwidth = libp2pmetrics.NewBandwidthCounter()
+			libp2pOpts = append(libp2pOpts, libp2p.BandwidthReporter(bandwidth))
+		}
+		if h, err = newHost(&hcfg, libp2pOpts...); err != nil {
 			return nil, err
 		}
+		g.SetNetwork(h.Network())
+		if relay != nil {
+			h.Network().Notify(relay.notifiee())
+			registerRelayCircuits(o.metrics, h)
+		}
+		if bandwidth != nil {
+			commons.RegisterCollector(o.metrics, newBandwidthCollector(o.metrics, bandwidth, cfg.Bandwidth.MaxPeers))
+		}
 	}
-	h, err := libp2p.New(opts...)
+	o.logger.Info("using libp2p host ", h.ID().String(), " ", h.Addrs())
+	ctx, cancel := context.WithCancel(pctx)
+	f := facade{
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		host:       h,
+		gater:      g,
+		relay:      relay,
+		relays:     relays,
+		bandwidth:  bandwidth,
+		registerer: registerer,
+		cfg:        cfg,
+		logger:     o.logger,
+		events:     events.NewBus(),
+		resolver:   o.resolver,
+	}
+	f.metrics = newMetrics(o.metrics)
+	f.streamMetrics = streams.NewMetrics(o.metrics)
+	if err := f.setup(o); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	f.logger.Debug("libp2p facade was created successfully")
+
+	return &f, nil
+}
+
+// newHost creates a new libp2p host from the given initialized config and additional options
+func newHost(cfg *config.Config, opts ...libp2p.Option) (host.Host, error) {
+	libp2pOpts, err := cfg.Libp2pOptions()
 	if err != nil {
 		return nil, err
 	}
-	logger.Info("created new libp2p host ", h.ID().String(), " ", h.Addrs())
-	f := facade{
-		ctx:  ctx,
-		host: h,
-		cfg:  cfg,
+	libp2pOpts = append(libp2pOpts, opts...)
+	return libp2p.New(libp2pOpts...)
+}
+
+type facade struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	cfg    *config.Config
+	host   host.Host
+	gater  *gater.Gater
+	relay  *relayReservations
+	ps     pubsub.PubsubService
+	mesh   *pubsub.MeshTracer
+	logger logging.StandardLogger
+	events *events.Bus
+
+	metrics       *metrics
+	streamMetrics *streams.Metrics
+	// bandwidth is nil if bandwidth accounting is disabled or an existing host was provided
+	bandwidth *libp2pmetrics.BandwidthCounter
+	// registerer keeps the registered collectors of the facade, it is nil if metrics are not registered
+	registerer *commons.Registerer
+
+	routing   routing.Routing
+	disc      discovery.Discovery
+	connector *connector
+	peers     *peerTracker
+	static    *staticPeers
+	nat       *natStatus
+	resolver  Resolver
+
+	bootstrapLock sync.RWMutex
+	bootstrap     []peer.AddrInfo
+	// relays is nil unless autorelay is enabled with DNS relayers
+	relays *relaySource
+
+	discovery     *CompositeDiscoverer
+	rendezvous    *rendezvous.Server
+	px            *peerExchange
+	stopDiscovery func()
+	admin         *admin.Server
+	// relayers []peer.AddrInfo
+
+	lock    sync.Mutex
+	started bool
+	closed  bool
+}
+
+// setup creates the components of the facade
+func (f *facade) setup(o *options) error {
+	f.nat = newNATStatus(f.host)
+	f.reportReachability(libp2pnetwork.ReachabilityUnknown)
+	if err := f.setupRouting(o.routing); err != nil {
+		return err
 	}
 
-	n, gc := Notiffee(h.Network())
+	if err := f.handleHostEvents(); err != nil {
+		return err
+	}
 
-	h.Network().Notify(n)
+	n, gc := notiffee(f.host.Network(), f.metrics.connections, f.events, f.logger)
+	f.host.Network().Notify(n)
 	go func() {
-		for ctx.Err() == nil {
-			time.Sleep(notiffeeCacheGCInterval)
-			gc()
+		ticker := time.NewTicker(notiffeeCacheGCInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				gc()
+				if f.bandwidth != nil {
+					// the meters of peers and protocols that are gone would be kept otherwise
+					f.bandwidth.TrimIdle(time.Now().Add(-bandwidthIdleTimeout))
+				}
+			case <-f.ctx.Done():
+				return
+			}
 		}
 	}()
+	f.peers = newPeerTracker()
+	f.host.Network().Notify(f.peers.notifiee())
 
-	backoffFactory := libp2pdisc.NewExponentialDecorrelatedJitter(
-		backoffLow, backoffHigh, backoffExponentBase, rand.NewSource(0))
-	backoffConnector, err := libp2pdisc.NewBackoffConnector(f.host, backoffConnectorCacheSize, connectTimeout, backoffFactory)
-	if err != nil {
-		return &f, err
+	backoffFactory := o.backoff
+	if backoffFactory == nil {
+		backoffFactory = libp2pdisc.NewExponentialDecorrelatedJitter(
+			backoffLow, backoffHigh, backoffExponentBase, rand.NewSource(0))
 	}
-	f.backoffConnector = backoffConnector
+	f.connector = newConnector(f.ctx, f.host, backoffFactory, f.metrics, f.peers, f.logger)
 
-	if len(f.cfg.MdnsServiceTag) > 0 {
-		f.mdnsq = make(ConnectQueue)
-		f.mdnsSvc = NewMdns(ctx, f.mdnsq, f.host, f.cfg.MdnsServiceTag)
-		logger.Info("using mdns discovery, tag ", f.cfg.MdnsServiceTag)
+	if err := f.setupPeers(); err != nil {
+		return err
 	}
 
-	if err := f.setupPubsub(); err != nil {
-		return &f, err
+	if err := f.setupDiscovery(o.discoverers); err != nil {
+		return err
+	}
+	if f.cfg.PeerExchange.Enabled {
+		f.px = newPeerExchange(f, o.metrics, f.cfg.PeerExchange)
+	}
+	if f.cfg.Rendezvous.Server.Enabled {
+		f.rendezvous = rendezvous.NewServer(f.StreamConfig(), f.cfg.Rendezvous.Server, o.metrics)
 	}
 
-	logger.Debug("libp2p facade was created successfully")
-
-	return &f, err
+	return f.setupPubsub(o.pubsubConfigurer, o.metrics)
 }
 
-type facade struct {
-	ctx  context.Context
-	cfg  *config.Config
-	host host.Host
-	ps   pubsub.PubsubService
-
-	routing          routing.Routing
-	backoffConnector *libp2pdisc.BackoffConnector
+// Start starts the background services of the facade, calling it more than once has no effect.
+// Once the facade was closed it cannot be started again.
+func (f *facade) Start(connectQ ConnectQueue) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 
-	mdnsSvc mdns.Service
-	mdnsq   ConnectQueue
-	// relayers []peer.AddrInfo
-}
+	if f.closed {
+		return ErrClosed
+	}
+	if f.started {
+		return nil
+	}
 
-func (f *facade) Start(connectQ ConnectQueue) error {
-	if f.mdnsSvc != nil {
-		if err := f.mdnsSvc.Start(); err != nil {
+	// the steps that can fail are done first, so the facade is not left half started and Start can be retried
+	if f.routing != nil {
+		if err := f.routing.Bootstrap(f.ctx); err != nil {
 			return err
 		}
-		f.startConnector(f.mdnsq)
+	}
+	if f.cfg.Admin.Enabled {
+		adminServer := admin.NewServer(f.ctx, f, f.cfg.Admin.Addr)
+		if err := adminServer.Start(); err != nil {
+			_ = adminServer.Close()
+			return errors.Wrap(err, "could not start admin server")
+		}
+		f.admin = adminServer
+		f.logger.Info("admin server is listening on ", f.admin.Addr().String())
+	}
+	f.started = true
+
+	go f.connector.run()
+
+	f.static.start(f.host)
+	if f.cfg.Latency.Interval > 0 && !f.cfg.DisablePing {
+		go newLatencyMonitor(f.ctx, f.host, f.metrics, f.logger, f.cfg.Latency).run()
+	}
+	if f.px != nil {
+		f.px.start()
+	}
+	for _, pi := range f.bootstrapPeers() {
+		f.connector.enqueue(pi, ConnectPriorityHigh, PeerSourceBootstrap)
+	}
+	if hasDNSAddrs(f.cfg.BootstrapPeers) || hasDNSAddrs(f.cfg.Relayers) {
+		go f.resolveDNSPeers()
+	}
+
+	if f.rendezvous != nil {
+		f.rendezvous.Start()
+		f.logger.Info("rendezvous server was started")
+	}
+	f.startDiscovery()
+
+	if len(f.cfg.DataDir) > 0 {
+		f.connectKnownPeers()
 	}
 
 	if connectQ != nil {
-		f.startConnector(connectQ)
+		f.startConnector(connectQ, ConnectPriorityNormal, PeerSourceConnectQueue)
 	}
 
-	if f.routing != nil {
-		if err := f.routing.Bootstrap(f.ctx); err != nil {
-			return err
-		}
+	if f.disc != nil && f.ps != nil && !f.cfg.TopicDiscovery.Disabled {
+		go newTopicDiscovery(f, f.cfg.TopicDiscovery).run()
 	}
 
 	return nil
 }
 
+// Close tears down the facade components in order and stops all background goroutines.
+// It is safe to call Close more than once.
+func (f *facade) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer close(f.done)
+	defer f.cancel()
+
+	var err error
+	if f.admin != nil {
+		err = multierr.Append(err, f.admin.Close())
+	}
+	if f.ps != nil {
+		for _, topicName := range f.ps.Topics() {
+			err = multierr.Append(err, errors.Wrapf(f.ps.UnSubscribe(topicName), "could not unsubscribe topic %s", topicName))
+		}
+	}
+	if f.stopDiscovery != nil {
+		f.stopDiscovery()
+	}
+	if f.px != nil {
+		f.px.close()
+	}
+	if f.rendezvous != nil {
+		err = multierr.Append(err, errors.Wrap(f.rendezvous.Close(), "could not close rendezvous server"))
+	}
+	if len(f.cfg.DataDir) > 0 {
+		f.keepKnownPeers()
+	}
+	if closer, ok := f.routing.(io.Closer); ok {
+		err = multierr.Append(err, errors.Wrap(closer.Close(), "could not close routing"))
+	}
+	err = multierr.Append(err, errors.Wrap(f.host.Close(), "could not close host"))
+	f.events.Close()
+	if f.registerer != nil {
+		f.registerer.UnregisterAll()
+	}
+
+	f.logger.Debug("libp2p facade was closed")
+
+	return err
+}
+
+// Done returns a channel that is closed once the facade was closed
+func (f *facade) Done() <-chan struct{} {
+	return f.done
+}
+
+// Gater implements Facade
+func (f *facade) Gater() *gater.Gater {
+	return f.gater
+}
+
+// AdminAddr implements Facade
+func (f *facade) AdminAddr() net.Addr {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.admin == nil {
+		return nil
+	}
+	return f.admin.Addr()
+}
+
 func (f *facade) Host() host.Host {
 	return f.host
 }
 
-func (f *facade) Close() error {
-	if err := f.host.Close(); err != nil {
-		return err
+// StreamConfig implements Facade
+func (f *facade) StreamConfig() streams.StreamConfig {
+	return streams.StreamConfig{
+		Ctx:            f.ctx,
+		Host:           f.host,
+		Metrics:        f.streamMetrics,
+		OnHandlerError: f.streamHandlerError,
+	}
+}
+
+// Routing implements Facade
+func (f *facade) Routing() routing.Routing {
+	return f.routing
+}
+
+// Discovery implements Facade
+func (f *facade) Discovery() discovery.Discovery {
+	return f.disc
+}
+
+// setupRouting creates routing with the given constructor,
+// and wraps the host so it will use routing to find unknown peers
+func (f *facade) setupRouting(newRouting func(h host.Host) (routing.Routing, error)) error {
+	if newRouting == nil {
+		return nil
+	}
+	r, err := newRouting(f.host)
+	if err != nil {
+		return errors.Wrap(err, "could not setup routing")
+	}
+	if r == nil {
+		return nil
 	}
+	f.routing = r
+	f.disc = libp2pdisc.NewRoutingDiscovery(r)
+	f.host = routedhost.Wrap(f.host, r)
+	f.logger.Debug("using routing")
 	return nil
 }
@@ -2,80 +2,422 @@ package p2pfacade
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"strings"
-	"sync"
-	"sync/atomic"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
-	"github.com/amirylm/libp2p-facade/streams"
-	logging "github.com/ipfs/go-log/v2"
-	core "github.com/libp2p/go-libp2p-core"
+	"github.com/amirylm/libp2p-facade/admin"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
+	pubsubfacade "github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	"github.com/stretchr/testify/require"
 )
 
-// TODO: remove timeouts
-func TestStreams(t *testing.T) {
+func TestRouting(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	require.NoError(t, logging.SetLogLevelRegex("p2p:.*", "debug"))
-	n := 10
-	nodes := newLocalNetwork(ctx, t, n)
-
-	<-time.After(2 * time.Second)
-
-	pid := core.ProtocolID("/mytest")
-	var wg sync.WaitGroup
-	var successMsgCount int64
-	for _, f := range nodes {
-		wg.Add(1)
-		go func(facade Facade) {
-			defer wg.Done()
-			facade.Host().SetStreamHandler(pid, func(s core.Stream) {
-				req, res, done, err := streams.HandleStream(s, 5*time.Second)
-				require.NoError(t, err)
-				defer func() {
-					_ = done()
-				}()
-				require.True(t, strings.Contains(string(req), "msg-from-node"))
-				require.NoError(t, res(req))
-				atomic.AddInt64(&successMsgCount, 1)
-			})
-		}(f)
+	n := 3
+	nodes := make([]Facade, n)
+	for i := 0; i < n; i++ {
+		cfg := newLocalConfig(ctx, i, n)
+		cfg.MdnsServiceTag = ""
+		cfg.Routing = func(h host.Host) (routing.Routing, error) {
+			kad, _, err := NewKadDHT(ctx, h, "test.dht", dht.ModeServer, nil)
+			return kad, err
+		}
+		f, err := New(ctx, WithConfig(cfg))
+		require.NoError(t, err)
+		require.NotNil(t, f.Routing())
+		require.NotNil(t, f.Discovery())
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+
+	// connecting 0 <> 1 <> 2, so 0 will need routing to find 2
+	for _, i := range []int{0, 2} {
+		require.NoError(t, nodes[i].Host().Connect(ctx, *host.InfoFromHost(nodes[1].Host())))
 	}
-	wg.Wait()
-	<-time.After(2 * time.Second)
-	t.Log("configured stream handlers", pid)
-	send := func(from, to Facade, i int) {
-		defer wg.Done()
-		res, err := streams.Request(to.Host().ID(), pid, []byte(fmt.Sprintf("msg-from-node-%d", i)), streams.StreamConfig{
-			Ctx:     ctx,
-			Host:    from.Host(),
-			Timeout: time.Second * 2,
-		})
+	require.Eventually(t, func() bool {
+		return nodes[0].Routing().(*dht.IpfsDHT).RoutingTable().Size() > 0 &&
+			nodes[1].Routing().(*dht.IpfsDHT).RoutingTable().Size() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the addresses of 2 might not be known to 1 yet (e.g. before identify completed)
+	var pi peer.AddrInfo
+	require.Eventually(t, func() bool {
+		fctx, fcancel := context.WithTimeout(ctx, time.Second)
+		defer fcancel()
+		var err error
+		pi, err = nodes[0].Routing().FindPeer(fctx, nodes[2].Host().ID())
+		return err == nil && len(pi.Addrs) > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, nodes[2].Host().ID(), pi.ID)
+}
+
+func TestNoRouting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := newLocalConfig(ctx, 0, 1)
+	cfg.MdnsServiceTag = ""
+	cfg.Routing = nil
+	f, err := New(ctx, WithConfig(cfg))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	require.Nil(t, f.Routing())
+	require.Nil(t, f.Discovery())
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := newLocalConfig(ctx, 0, 1)
+	cfg.MdnsServiceTag = "test.lifecycle.mdns"
+
+	// warming up, to let libp2p spin up its global goroutines
+	f, err := New(ctx, WithConfig(cfg))
+	require.NoError(t, err)
+	require.NoError(t, f.Start(nil))
+	require.NoError(t, f.Close())
+	<-time.After(100 * time.Millisecond)
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 3; i++ {
+		f, err := New(ctx, WithConfig(cfg))
 		require.NoError(t, err)
-		require.True(t, strings.Contains(string(res), "msg-from-node"))
+		// restarted node should have the same identity
+		require.Equal(t, cfg.PrivateKey.GetPublic(), f.Host().Peerstore().PubKey(f.Host().ID()))
+		require.NoError(t, f.Start(nil))
+		require.NoError(t, f.Start(nil))
+		require.NoError(t, f.Subscribe("test.lifecycle", func(m *pubsub.Message) {}, 0))
+
+		select {
+		case <-f.Done():
+			t.Fatal("done channel should be open before close")
+		default:
+		}
+
+		require.NoError(t, f.Close())
+		require.NoError(t, f.Close())
+		require.ErrorIs(t, f.Start(nil), ErrClosed)
+		select {
+		case <-f.Done():
+		case <-time.After(time.Second):
+			t.Fatal("done channel should be closed after close")
+		}
+	}
+
+	// polling without require.Eventually as it spawns a goroutine for every check
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		<-time.After(50 * time.Millisecond)
 	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "goroutines leaked")
+}
+
+func TestAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := newLocalConfig(ctx, 0, 1)
+	cfg.MdnsServiceTag = ""
+	cfg.Routing = nil
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	cfg.Admin = config.AdminConfig{Enabled: true, Addr: "unix://" + sock}
+	f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+	require.NoError(t, err)
+	require.Nil(t, f.AdminAddr())
+	require.NoError(t, f.Start(nil))
+	require.NotNil(t, f.AdminAddr())
+	require.Equal(t, sock, f.AdminAddr().String())
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	res, err := client.Get("http://admin/identity")
+	require.NoError(t, err)
+	var id admin.Identity
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&id))
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, f.Host().ID().String(), id.ID)
+
+	require.NoError(t, f.Close())
+	_, err = client.Get("http://admin/identity")
+	require.Error(t, err)
+}
 
-	wg.Add(4)
-	go func() {
-		go send(nodes[0], nodes[1], 0)
-		go send(nodes[2], nodes[5], 2)
+func TestStartFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cfg := newLocalConfig(ctx, 0, 1)
+	cfg.MdnsServiceTag = ""
+	cfg.Routing = nil
+	cfg.Admin = config.AdminConfig{Enabled: true, Addr: l.Addr().String()}
+	f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
 	}()
-	go send(nodes[0], nodes[3], 0)
-	go send(nodes[5], nodes[4], 5)
 
-	wg.Wait()
+	// the admin address is in use, the facade is not started
+	require.Error(t, f.Start(nil))
+	require.Error(t, f.Start(nil))
+	require.Nil(t, f.AdminAddr())
+	require.False(t, f.(*facade).started)
 
-	<-time.After(2 * time.Second)
+	require.NoError(t, l.Close())
+	require.NoError(t, f.Start(nil))
+	require.Equal(t, cfg.Admin.Addr, f.AdminAddr().String())
+}
 
-	require.GreaterOrEqual(t, atomic.LoadInt64(&successMsgCount), int64(3))
+func TestConnManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	for _, f := range nodes {
+	n := 4
+	nodes := make([]Facade, n)
+	for i := 0; i < n; i++ {
+		cfg := newLocalConfig(ctx, i, n)
+		cfg.MdnsServiceTag = ""
+		cfg.Routing = nil
+		// pubsub might reconnect trimmed peers
+		cfg.PubsubConfigurer = nil
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		if i == 0 {
+			cfg.ConnManager = config.ConnManagerConfig{LowWater: 1, HighWater: 2, GracePeriod: time.Millisecond}
+		}
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+
+	for _, f := range nodes[1:] {
+		require.NoError(t, nodes[0].Host().Connect(ctx, *host.InfoFromHost(f.Host())))
+	}
+	protected := nodes[1].Host().ID()
+	nodes[0].Protect(protected, "test")
+	nodes[0].TagPeer(nodes[2].Host().ID(), "test", 10)
+	// connections are reported to the connection manager asynchronously
+	cm := nodes[0].Host().ConnManager().(*connmgr.BasicConnMgr)
+	require.Eventually(t, func() bool {
+		for _, f := range nodes[1:] {
+			if info := cm.GetTagInfo(f.Host().ID()); info == nil || len(info.Conns) == 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	// waiting for the grace period
+	<-time.After(10 * time.Millisecond)
+
+	// the protected peer is not a candidate, so the peer with the lowest value is trimmed
+	cm.TrimOpenConns(ctx)
+	require.Equal(t, libp2pnetwork.Connected, nodes[0].Host().Network().Connectedness(protected))
+	require.Equal(t, libp2pnetwork.Connected, nodes[0].Host().Network().Connectedness(nodes[2].Host().ID()))
+	require.NotEqual(t, libp2pnetwork.Connected, nodes[0].Host().Network().Connectedness(nodes[3].Host().ID()))
+	require.False(t, nodes[0].Unprotect(protected, "test"))
+}
+
+func TestGater(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := 4
+	nodes := make([]Facade, n)
+	for i := 0; i < n; i++ {
+		cfg := newLocalConfig(ctx, i, n)
+		cfg.MdnsServiceTag = ""
+		cfg.Routing = nil
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		require.NotNil(t, f.Gater())
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+	g := nodes[0].Gater()
+
+	g.DenyPeer(nodes[1].Host().ID())
+	require.Error(t, nodes[0].Host().Connect(ctx, *host.InfoFromHost(nodes[1].Host())))
+	require.Error(t, nodes[1].Host().Connect(ctx, *host.InfoFromHost(nodes[0].Host())))
+	g.UndenyPeer(nodes[1].Host().ID())
+	clearBackoff(nodes[1], nodes[0])
+	require.NoError(t, nodes[1].Host().Connect(ctx, *host.InfoFromHost(nodes[0].Host())))
+
+	// all nodes are on the same ip, so only a single inbound connection is accepted
+	g.SetLimits(1, 0)
+	require.Error(t, nodes[2].Host().Connect(ctx, *host.InfoFromHost(nodes[0].Host())))
+	g.SetLimits(0, 2)
+	clearBackoff(nodes[2], nodes[0])
+	require.NoError(t, nodes[2].Host().Connect(ctx, *host.InfoFromHost(nodes[0].Host())))
+	require.Error(t, nodes[3].Host().Connect(ctx, *host.InfoFromHost(nodes[0].Host())))
+
+	// outbound connections are not limited
+	require.NoError(t, nodes[0].Host().Connect(ctx, *host.InfoFromHost(nodes[3].Host())))
+}
+
+func TestDataDir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataDir := t.TempDir()
+	newNode := func(i int, dataDir string) Facade {
+		cfg := newLocalConfig(ctx, i, 2)
+		cfg.MdnsServiceTag = ""
+		cfg.Routing = nil
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.DataDir = dataDir
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		return f
+	}
+	other := newNode(0, "")
+	defer func() {
+		require.NoError(t, other.Close())
+	}()
+
+	f := newNode(1, dataDir)
+	pid := f.Host().ID()
+	require.NoError(t, f.Host().Connect(ctx, *host.InfoFromHost(other.Host())))
+	require.NoError(t, f.Close())
+
+	// the datastore is closed if the facade could not be created, so the data directory is not kept locked
+	cfg := &config.Config{}
+	cfg.ListenAddrs = []string{"/invalid"}
+	cfg.DataDir = dataDir
+	_, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+	require.Error(t, err)
+
+	// the identity and the known peers are loaded from the data directory
+	f = newNode(1, dataDir)
+	defer func() {
 		require.NoError(t, f.Close())
+	}()
+	require.Equal(t, pid, f.Host().ID())
+	known := f.Host().Peerstore().Addrs(other.Host().ID())
+	require.Len(t, known, 1)
+	require.True(t, other.Host().Addrs()[0].Equal(known[0]))
+	require.Eventually(t, func() bool {
+		pi, ok := f.PeerInfo(other.Host().ID())
+		return ok && pi.Source == PeerSourcePeerstore
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStaticPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func(i int, static, bootstrap []string) Facade {
+		cfg := newLocalConfig(ctx, i, 3)
+		cfg.MdnsServiceTag = ""
+		cfg.Routing = nil
+		cfg.PubsubConfigurer = nil
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.StaticPeers = static
+		cfg.BootstrapPeers = bootstrap
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil),
+			WithConnectorBackoff(libp2pdisc.NewFixedBackoff(10*time.Millisecond)))
+		require.NoError(t, err)
+		return f
 	}
+	p2pAddr := func(f Facade) string {
+		addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(f.Host()))
+		require.NoError(t, err)
+		return addrs[0].String()
+	}
+	static, bootstrap := newNode(0, nil, nil), newNode(1, nil, nil)
+	f := newNode(2, []string{p2pAddr(static)}, []string{p2pAddr(bootstrap)})
+	defer func() {
+		for _, n := range []Facade{f, static, bootstrap} {
+			require.NoError(t, n.Close())
+		}
+	}()
+	evts, cancelEvts := f.SubscribeEvents(16, events.StaticPeerConnected{}, events.StaticPeerDisconnected{})
+	defer cancelEvts()
+	nextEvent := func() events.Event {
+		select {
+		case evt := <-evts:
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for static peer event")
+			return nil
+		}
+	}
+	require.NoError(t, f.Start(nil))
+
+	require.Equal(t, events.StaticPeerConnected{Peer: static.Host().ID()}, nextEvent())
+	require.Eventually(t, func() bool {
+		pi, ok := f.PeerInfo(bootstrap.Host().ID())
+		return ok && pi.Source == PeerSourceBootstrap
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, f.Host().ConnManager().IsProtected(static.Host().ID(), staticPeerTag))
+	require.False(t, f.Host().ConnManager().IsProtected(bootstrap.Host().ID(), staticPeerTag))
 
-	t.Logf("done")
+	// static peers are reconnected once disconnected
+	require.NoError(t, static.Host().Network().ClosePeer(f.Host().ID()))
+	require.Equal(t, events.StaticPeerDisconnected{Peer: static.Host().ID()}, nextEvent())
+	require.Equal(t, events.StaticPeerConnected{Peer: static.Host().ID()}, nextEvent())
+	require.Eventually(t, func() bool {
+		pi, ok := f.PeerInfo(static.Host().ID())
+		return ok && pi.Source == PeerSourceStatic
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// clearBackoff clears the dial backoff of the given node to the given peer
+func clearBackoff(f, p Facade) {
+	f.Host().Network().(*swarm.Swarm).Backoff().Clear(p.Host().ID())
+}
+
+func newLocalConfig(ctx context.Context, i, maxPeers int) *config.Config {
+	cfg := config.Config{
+		Routing: func(h host.Host) (routing.Routing, error) {
+			kad, _, err := NewKadDHT(ctx, h, "test.dht", dht.ModeAutoServer, nil)
+			return kad, err
+		},
+	}
+	cfg.PubsubConfigurer = pubsubfacade.NewNilConfigurer()
+	cfg.ListenAddrs = []string{"/ip4/0.0.0.0/tcp/0"}
+	cfg.UserAgent = fmt.Sprintf("test/v0/%d", i)
+	cfg.MdnsServiceTag = "test.mdns"
+	return &cfg
 }
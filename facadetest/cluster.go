@@ -0,0 +1,252 @@
+package facadetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const (
+	// pollInterval is the interval used when waiting for some condition
+	pollInterval = 5 * time.Millisecond
+	// backoffInterval is the fixed backoff of the nodes connector,
+	// a short interval allows to retry connecting to peers quickly
+	backoffInterval = 50 * time.Millisecond
+)
+
+// ClusterConfig is the config used to build a cluster
+type ClusterConfig struct {
+	// N is the number of nodes in the cluster
+	N int
+	// Topology determines which nodes are linked and connected, FullMesh is used if nil
+	Topology Topology
+	// Latency is the latency of every link
+	Latency time.Duration
+	// Bandwidth is the bandwidth of every link in bytes per second, unlimited if zero
+	Bandwidth float64
+	// Options returns additional facade options for the i-th node, optional.
+	// Metrics are not registered by default (see p2pfacade.WithMetricsRegistry),
+	// and the connector uses a short fixed backoff (see p2pfacade.WithConnectorBackoff).
+	Options func(i int) []p2pfacade.Option
+}
+
+// Cluster is a set of facades on top of an in-memory network (libp2p mocknet)
+type Cluster struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	cfg    ClusterConfig
+
+	// Net is the underlying mocknet
+	Net mocknet.Mocknet
+	// Nodes are the facades in the cluster
+	Nodes []p2pfacade.Facade
+	// Links are the links between nodes according to the topology
+	Links []Link
+
+	keys     []crypto.PrivKey
+	addrs    []ma.Multiaddr
+	connectQ []p2pfacade.ConnectQueue
+
+	lock    sync.RWMutex
+	cut     map[Link]bool
+	crashed map[int]bool
+	// closedConns are the connections that were closed when links were cut or nodes crashed
+	closedConns map[Link][]network.Conn
+}
+
+// NewCluster builds a cluster of started facades, linked and connected according to the topology.
+// It returns once all the links are connected and the peers were identified.
+func NewCluster(ctx context.Context, cfg ClusterConfig) (*Cluster, error) {
+	if cfg.Topology == nil {
+		cfg.Topology = FullMesh()
+	}
+	mn := mocknet.New()
+	mn.SetLinkDefaults(mocknet.LinkOptions{
+		Latency:   cfg.Latency,
+		Bandwidth: cfg.Bandwidth,
+	})
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Cluster{
+		ctx:      ctx,
+		cancel:   cancel,
+		cfg:      cfg,
+		Net:      mn,
+		Nodes:    make([]p2pfacade.Facade, cfg.N),
+		Links:    cfg.Topology(cfg.N),
+		keys:     make([]crypto.PrivKey, cfg.N),
+		addrs:    make([]ma.Multiaddr, cfg.N),
+		connectQ: make([]p2pfacade.ConnectQueue, cfg.N),
+		cut:      make(map[Link]bool),
+		crashed:  make(map[int]bool),
+
+		closedConns: make(map[Link][]network.Conn),
+	}
+
+	for i := 0; i < cfg.N; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not generate key")
+		}
+		c.keys[i] = sk
+		addr, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/10.0.%d.%d/tcp/4242", i/256, i%256))
+		if err != nil {
+			return nil, err
+		}
+		c.addrs[i] = addr
+		if err := c.newNode(i); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+
+	for _, l := range c.Links {
+		if err := c.link(l); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		if err := c.connect(l); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+
+	if err := c.WaitConnected(ctx); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	go c.resetClosedStreams()
+
+	return c, nil
+}
+
+// newNode creates and starts the i-th node, the node is connected to peers that are sent on its connect queue
+func (c *Cluster) newNode(i int) error {
+	h, err := c.Net.AddPeer(c.keys[i], c.addrs[i])
+	if err != nil {
+		return errors.Wrapf(err, "could not add peer %d", i)
+	}
+	opts := []p2pfacade.Option{
+		p2pfacade.WithMetricsRegistry(nil),
+		p2pfacade.WithConnectorBackoff(libp2pdisc.NewFixedBackoff(backoffInterval)),
+	}
+	if c.cfg.Options != nil {
+		opts = append(opts, c.cfg.Options(i)...)
+	}
+	opts = append(opts, p2pfacade.WithHost(h))
+	f, err := p2pfacade.New(c.ctx, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "could not create node %d", i)
+	}
+	connectQ := make(p2pfacade.ConnectQueue)
+	if err := f.Start(connectQ); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "could not start node %d", i)
+	}
+	c.Nodes[i] = f
+	c.connectQ[i] = connectQ
+	return nil
+}
+
+// link links the nodes of the given link
+func (c *Cluster) link(l Link) error {
+	a, b := c.Nodes[l[0]].Host().ID(), c.Nodes[l[1]].Host().ID()
+	if _, err := c.Net.LinkPeers(a, b); err != nil {
+		return errors.Wrapf(err, "could not link nodes %d, %d", l[0], l[1])
+	}
+	delete(c.closedConns, l)
+	return nil
+}
+
+// connect connects the nodes of the given link, the nodes must be linked
+func (c *Cluster) connect(l Link) error {
+	a, b := c.Nodes[l[0]].Host().ID(), c.Nodes[l[1]].Host().ID()
+	if _, err := c.Net.ConnectPeers(a, b); err != nil {
+		return errors.Wrapf(err, "could not connect nodes %d, %d", l[0], l[1])
+	}
+	return nil
+}
+
+// WaitConnected waits until the nodes of every active link are connected and identified each other
+func (c *Cluster) WaitConnected(ctx context.Context) error {
+	return WaitFor(ctx, func() bool {
+		for _, l := range c.ActiveLinks() {
+			if !c.identified(l[0], l[1]) || !c.identified(l[1], l[0]) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// identified returns whether node i is connected to node j, and knows its protocols
+func (c *Cluster) identified(i, j int) bool {
+	h := c.Nodes[i].Host()
+	pid := c.Nodes[j].Host().ID()
+	if h.Network().Connectedness(pid) != network.Connected {
+		return false
+	}
+	protocols, err := h.Peerstore().GetProtocols(pid)
+	return err == nil && len(protocols) > 0
+}
+
+// WaitForTopicPeers waits until every live node knows at least minPeers that are subscribed to the given topic
+func (c *Cluster) WaitForTopicPeers(ctx context.Context, topicName string, minPeers int) error {
+	return WaitFor(ctx, func() bool {
+		for _, f := range c.LiveNodes() {
+			topic := f.GetTopic(topicName)
+			if topic == nil || len(topic.ListPeers()) < minPeers {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// WaitForMesh waits until every live node has at least minPeers in its mesh of the given topic
+func (c *Cluster) WaitForMesh(ctx context.Context, topicName string, minPeers int) error {
+	return WaitFor(ctx, func() bool {
+		for _, f := range c.LiveNodes() {
+			if len(f.MeshPeers(topicName)) < minPeers {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Close closes all the nodes and the underlying network
+func (c *Cluster) Close() error {
+	var err error
+	for _, f := range c.Nodes {
+		if f != nil {
+			err = multierr.Append(err, f.Close())
+		}
+	}
+	err = multierr.Append(err, c.Net.Close())
+	c.cancel()
+	return err
+}
+
+// WaitFor polls the given condition until it is met or the context is done
+func WaitFor(ctx context.Context, cond func() bool) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for !cond() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package facadetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopology(t *testing.T) {
+	require.Len(t, FullMesh()(5), 10)
+	require.Len(t, Ring()(5), 5)
+	require.Len(t, Ring()(2), 1)
+	require.Len(t, Star()(5), 4)
+
+	links := RandomDegree(3, 1)(10)
+	degrees := make([]int, 10)
+	for _, l := range links {
+		require.NotEqual(t, l[0], l[1])
+		degrees[l[0]]++
+		degrees[l[1]]++
+	}
+	for _, d := range degrees {
+		require.GreaterOrEqual(t, d, 3)
+	}
+	require.Equal(t, links, RandomDegree(3, 1)(10), "same seed should produce the same topology")
+}
+
+func TestCluster(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	n := 5
+	c, err := NewCluster(ctx, ClusterConfig{
+		N:        n,
+		Topology: Star(),
+		Latency:  time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+
+	require.Len(t, c.Nodes, n)
+	require.Len(t, c.Nodes[0].Host().Network().Peers(), n-1)
+	for _, f := range c.Nodes[1:] {
+		require.Len(t, f.Host().Network().Peers(), 1)
+	}
+}
@@ -0,0 +1,211 @@
+package facadetest
+
+import (
+	"context"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// LiveNodes returns the nodes that are not crashed
+func (c *Cluster) LiveNodes() []p2pfacade.Facade {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	nodes := make([]p2pfacade.Facade, 0, len(c.Nodes))
+	for i, f := range c.Nodes {
+		if !c.crashed[i] {
+			nodes = append(nodes, f)
+		}
+	}
+	return nodes
+}
+
+// ActiveLinks returns the links of the topology that are not cut, between nodes that are not crashed
+func (c *Cluster) ActiveLinks() []Link {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	links := make([]Link, 0, len(c.Links))
+	for _, l := range c.Links {
+		if c.active(l) {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// active returns whether the given link is active, assumes the lock is acquired
+func (c *Cluster) active(l Link) bool {
+	return !c.cut[l] && !c.crashed[l[0]] && !c.crashed[l[1]]
+}
+
+// Partition splits the nodes into the given groups, nodes that were not specified form another group.
+// Links between nodes of different groups are cut, and the nodes are disconnected.
+func (c *Cluster) Partition(groups ...[]int) error {
+	group := make(map[int]int)
+	for g, nodes := range groups {
+		for _, i := range nodes {
+			group[i] = g + 1
+		}
+	}
+	var err error
+	for _, l := range c.Links {
+		if group[l[0]] != group[l[1]] {
+			err = multierr.Append(err, c.DropLink(l))
+		}
+	}
+	return err
+}
+
+// Heal restores all the links that were cut, the nodes are not connected (see Discover).
+func (c *Cluster) Heal() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var err error
+	for l := range c.cut {
+		delete(c.cut, l)
+		if c.active(l) {
+			err = multierr.Append(err, c.link(l))
+		}
+	}
+	return err
+}
+
+// DropLink cuts the given link and disconnects its nodes, until Heal is called
+func (c *Cluster) DropLink(l Link) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	active := c.active(l)
+	c.cut[l] = true
+	if !active {
+		return nil
+	}
+	return c.unlink(l)
+}
+
+// DelayLink sets the latency of the given link
+func (c *Cluster) DelayLink(l Link, latency time.Duration) error {
+	links := c.Net.LinksBetweenPeers(c.ID(l[0]), c.ID(l[1]))
+	if len(links) == 0 {
+		return errors.Errorf("nodes %d, %d are not linked", l[0], l[1])
+	}
+	for _, link := range links {
+		opts := link.Options()
+		opts.Latency = latency
+		link.SetOptions(opts)
+	}
+	return nil
+}
+
+// Crash closes the i-th node and cuts its links, the node can be restarted with Restart
+func (c *Cluster) Crash(i int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.crashed[i] {
+		return nil
+	}
+	var err error
+	for _, l := range c.Links {
+		if (l[0] == i || l[1] == i) && c.active(l) {
+			err = multierr.Append(err, c.unlink(l))
+		}
+	}
+	c.crashed[i] = true
+	return multierr.Append(err, c.Nodes[i].Close())
+}
+
+// Restart creates a new node in place of the crashed i-th node, with the same key and address.
+// The active links of the node are restored, but it is not connected to other nodes (see Discover).
+func (c *Cluster) Restart(i int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.crashed[i] {
+		return errors.Errorf("node %d is not crashed", i)
+	}
+	if err := c.newNode(i); err != nil {
+		return err
+	}
+	delete(c.crashed, i)
+	for _, l := range c.Links {
+		if (l[0] == i || l[1] == i) && c.active(l) {
+			if err := c.link(l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Discover sends the given peers on the connect queue of the i-th node, as if they were discovered
+func (c *Cluster) Discover(ctx context.Context, i int, peers ...int) error {
+	c.lock.RLock()
+	connectQ := c.connectQ[i]
+	c.lock.RUnlock()
+
+	for _, j := range peers {
+		select {
+		case connectQ <- c.AddrInfo(j):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ID returns the peer ID of the i-th node
+func (c *Cluster) ID(i int) peer.ID {
+	id, _ := peer.IDFromPrivateKey(c.keys[i])
+	return id
+}
+
+// AddrInfo returns the address info of the i-th node
+func (c *Cluster) AddrInfo(i int) peer.AddrInfo {
+	return peer.AddrInfo{ID: c.ID(i), Addrs: []ma.Multiaddr{c.addrs[i]}}
+}
+
+// unlink unlinks and disconnects the nodes of the given link, unlinking first ensures the nodes won't reconnect.
+// The connections are closed on both sides, as mocknet closes the remote side asynchronously.
+func (c *Cluster) unlink(l Link) error {
+	a, b := c.ID(l[0]), c.ID(l[1])
+	if err := c.Net.UnlinkPeers(a, b); err != nil {
+		return errors.Wrapf(err, "could not unlink nodes %d, %d", l[0], l[1])
+	}
+	c.closedConns[l] = append(c.Nodes[l[0]].Host().Network().ConnsToPeer(b),
+		c.Nodes[l[1]].Host().Network().ConnsToPeer(a)...)
+	err := c.Net.DisconnectPeers(a, b)
+	err = multierr.Append(err, c.Net.DisconnectPeers(b, a))
+	return errors.Wrapf(err, "could not disconnect nodes %d, %d", l[0], l[1])
+}
+
+// resetClosedStreams resets streams that were opened on closed connections, until the cluster is closed.
+// mocknet resets the streams of a connection before removing it, therefore protocols that reopen streams
+// while still connected (e.g. pubsub) might open streams on a closed connection that will never be reset.
+func (c *Cluster) resetClosedStreams() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+		c.lock.RLock()
+		for _, conns := range c.closedConns {
+			for _, conn := range conns {
+				for _, s := range conn.GetStreams() {
+					_ = s.Reset()
+				}
+			}
+		}
+		c.lock.RUnlock()
+	}
+}
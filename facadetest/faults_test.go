@@ -0,0 +1,163 @@
+package facadetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/libp2p/go-libp2p-core/network"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n := 6
+	c, err := NewCluster(ctx, ClusterConfig{
+		N: n,
+		Options: func(i int) []p2pfacade.Option {
+			return []p2pfacade.Option{p2pfacade.WithPubsubConfigurer(NewPubsubConfigurer())}
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+
+	topicName := "test.partition"
+	received := newReceived()
+	for i, f := range c.Nodes {
+		require.NoError(t, f.Subscribe(topicName, received.handler(i), n))
+	}
+	require.NoError(t, c.WaitForMesh(ctx, topicName, 2))
+
+	require.NoError(t, c.Partition([]int{0, 1, 2}))
+	require.Len(t, c.ActiveLinks(), 6)
+	require.Equal(t, network.NotConnected, c.Nodes[0].Host().Network().Connectedness(c.ID(3)))
+
+	// wait for pubsub to drop the peers of the other group
+	require.NoError(t, WaitFor(ctx, func() bool {
+		for _, f := range c.Nodes {
+			if len(f.GetTopic(topicName).ListPeers()) != 2 {
+				return false
+			}
+		}
+		return true
+	}))
+	require.NoError(t, c.Nodes[0].Publish(topicName, []byte("partitioned")))
+	require.NoError(t, WaitFor(ctx, func() bool {
+		return received.count("partitioned") == 3
+	}), "%v", received.msgs)
+	// the connector should fail to connect while partitioned
+	require.NoError(t, c.Discover(ctx, 0, 3))
+	<-time.After(backoffInterval * 2)
+	require.Equal(t, 0, received.count("partitioned", 3, 4, 5))
+	require.NotEqual(t, network.Connected, c.Nodes[0].Host().Network().Connectedness(c.ID(3)))
+
+	require.NoError(t, c.Heal())
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Discover(ctx, i, 3, 4, 5))
+	}
+	require.NoError(t, c.WaitConnected(ctx))
+	require.NoError(t, c.WaitForMesh(ctx, topicName, 4))
+
+	require.NoError(t, c.Nodes[0].Publish(topicName, []byte("healed")))
+	require.NoError(t, WaitFor(ctx, func() bool {
+		return received.count("healed") == n
+	}))
+}
+
+func TestCrashRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n := 4
+	c, err := NewCluster(ctx, ClusterConfig{
+		N:        n,
+		Topology: Ring(),
+		Options: func(i int) []p2pfacade.Option {
+			return []p2pfacade.Option{p2pfacade.WithPubsubConfigurer(NewPubsubConfigurer())}
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+
+	topicName := "test.crash"
+	received := newReceived()
+	for i, f := range c.Nodes {
+		require.NoError(t, f.Subscribe(topicName, received.handler(i), n))
+	}
+	require.NoError(t, c.WaitForMesh(ctx, topicName, 2))
+
+	require.NoError(t, c.Crash(1))
+	require.Len(t, c.LiveNodes(), n-1)
+	require.Len(t, c.ActiveLinks(), 2)
+	select {
+	case <-c.Nodes[1].Done():
+	default:
+		t.Fatal("crashed node should be closed")
+	}
+	require.NoError(t, c.Nodes[0].Publish(topicName, []byte("crashed")))
+	require.NoError(t, WaitFor(ctx, func() bool {
+		return received.count("crashed") == n-1
+	}))
+
+	require.NoError(t, c.Restart(1))
+	require.Equal(t, c.ID(1), c.Nodes[1].Host().ID())
+	require.NoError(t, c.Nodes[1].Subscribe(topicName, received.handler(1), n))
+	require.NoError(t, c.Discover(ctx, 0, 1))
+	require.NoError(t, c.Discover(ctx, 2, 1))
+	require.NoError(t, c.WaitConnected(ctx))
+	require.NoError(t, c.WaitForMesh(ctx, topicName, 2))
+
+	require.NoError(t, c.DelayLink(Link{0, 1}, 10*time.Millisecond))
+	require.NoError(t, c.Nodes[0].Publish(topicName, []byte("restarted")))
+	require.NoError(t, WaitFor(ctx, func() bool {
+		return received.count("restarted") == n
+	}))
+	require.Error(t, c.Restart(1))
+}
+
+// received tracks the messages that were received by each node
+type received struct {
+	lock *sync.Mutex
+	msgs map[string]map[int]int
+}
+
+func newReceived() *received {
+	return &received{lock: &sync.Mutex{}, msgs: make(map[string]map[int]int)}
+}
+
+func (r *received) handler(i int) func(*pubsublibp2p.Message) {
+	return func(msg *pubsublibp2p.Message) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		data := string(msg.GetData())
+		if r.msgs[data] == nil {
+			r.msgs[data] = make(map[int]int)
+		}
+		r.msgs[data][i]++
+	}
+}
+
+// count returns the number of the given nodes (or all nodes) that received the given message
+func (r *received) count(data string, nodes ...int) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(nodes) == 0 {
+		return len(r.msgs[data])
+	}
+	count := 0
+	for _, i := range nodes {
+		if r.msgs[data][i] > 0 {
+			count++
+		}
+	}
+	return count
+}
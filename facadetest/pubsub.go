@@ -0,0 +1,32 @@
+package facadetest
+
+import (
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+)
+
+const (
+	// heartbeatInterval is the gossipsub heartbeat interval used in tests,
+	// a short interval helps to form the mesh quickly
+	heartbeatInterval = 50 * time.Millisecond
+)
+
+// NewPubsubConfigurer returns a pubsub configurer that uses short gossipsub intervals
+func NewPubsubConfigurer() config.PubsubConfigurer {
+	return &pubsubConfigurer{pubsub.NewNilConfigurer()}
+}
+
+type pubsubConfigurer struct {
+	config.PubsubConfigurer
+}
+
+// Opts implements Configurer
+func (*pubsubConfigurer) Opts() []pubsublibp2p.Option {
+	params := pubsublibp2p.DefaultGossipSubParams()
+	params.HeartbeatInitialDelay = heartbeatInterval / 5
+	params.HeartbeatInterval = heartbeatInterval
+	return []pubsublibp2p.Option{pubsublibp2p.WithGossipSubParams(params)}
+}
@@ -0,0 +1,91 @@
+package facadetest
+
+import (
+	"math/rand"
+)
+
+// Link is a pair of node indices
+type Link [2]int
+
+// Topology returns the links between n nodes
+type Topology func(n int) []Link
+
+// FullMesh links every node to all other nodes
+func FullMesh() Topology {
+	return func(n int) []Link {
+		links := make([]Link, 0)
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				links = append(links, Link{i, j})
+			}
+		}
+		return links
+	}
+}
+
+// Ring links every node to the next one, and the last node to the first one
+func Ring() Topology {
+	return func(n int) []Link {
+		links := make([]Link, 0)
+		if n < 2 {
+			return links
+		}
+		for i := 0; i < n-1; i++ {
+			links = append(links, Link{i, i + 1})
+		}
+		if n > 2 {
+			links = append(links, Link{n - 1, 0})
+		}
+		return links
+	}
+}
+
+// Star links the first node to all other nodes
+func Star() Topology {
+	return func(n int) []Link {
+		links := make([]Link, 0)
+		for i := 1; i < n; i++ {
+			links = append(links, Link{0, i})
+		}
+		return links
+	}
+}
+
+// RandomDegree links every node to at least d random nodes, using the given seed.
+// Nodes are also linked in a ring to ensure the network is connected.
+func RandomDegree(d int, seed int64) Topology {
+	return func(n int) []Link {
+		r := rand.New(rand.NewSource(seed))
+		degrees := make([]int, n)
+		linked := make(map[Link]bool)
+		links := make([]Link, 0)
+		add := func(i, j int) {
+			if i == j {
+				return
+			}
+			if i > j {
+				i, j = j, i
+			}
+			l := Link{i, j}
+			if linked[l] {
+				return
+			}
+			linked[l] = true
+			degrees[i]++
+			degrees[j]++
+			links = append(links, l)
+		}
+		for _, l := range Ring()(n) {
+			add(l[0], l[1])
+		}
+		if d >= n {
+			d = n - 1
+		}
+		for i := 0; i < n; i++ {
+			for degrees[i] < d {
+				add(i, r.Intn(n))
+			}
+		}
+		return links
+	}
+}
@@ -0,0 +1,371 @@
+package gater
+
+import (
+	"net"
+	"sync"
+
+	"github.com/amirylm/libp2p-facade/config"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ipv4SubnetBits is the size of ipv4 subnets when limiting connections per subnet
+	ipv4SubnetBits = 24
+	// ipv6SubnetBits is the size of ipv6 subnets when limiting connections per subnet
+	ipv6SubnetBits = 64
+)
+
+// rejection reasons, used as metrics labels
+const (
+	reasonDeniedPeer     = "denied_peer"
+	reasonNotAllowedPeer = "not_allowed_peer"
+	reasonDeniedAddr     = "denied_addr"
+	reasonNotAllowedAddr = "not_allowed_addr"
+	reasonIPLimit        = "ip_limit"
+	reasonSubnetLimit    = "subnet_limit"
+)
+
+var (
+	logger = logging.Logger("p2p:gater")
+)
+
+// Gater is a connection gater with allow/deny lists of peers and subnets, and limits of inbound connections
+// per IP and subnet. It implements connmgr.ConnectionGater and can be updated in runtime.
+type Gater struct {
+	lock *sync.RWMutex
+
+	allowedPeers map[peer.ID]struct{}
+	deniedPeers  map[peer.ID]struct{}
+	allowedCIDRs map[string]*net.IPNet
+	deniedCIDRs  map[string]*net.IPNet
+
+	maxConnsPerIP     int
+	maxConnsPerSubnet int
+
+	net     network.Network
+	metrics *Metrics
+}
+
+// New creates a new gater from the given config, no-op metrics are used if nil
+func New(cfg config.GaterConfig, metrics *Metrics) (*Gater, error) {
+	if metrics == nil {
+		metrics = defaultMetrics
+	}
+	g := &Gater{
+		lock:              &sync.RWMutex{},
+		allowedPeers:      make(map[peer.ID]struct{}),
+		deniedPeers:       make(map[peer.ID]struct{}),
+		allowedCIDRs:      make(map[string]*net.IPNet),
+		deniedCIDRs:       make(map[string]*net.IPNet),
+		maxConnsPerIP:     cfg.MaxConnsPerIP,
+		maxConnsPerSubnet: cfg.MaxConnsPerSubnet,
+		metrics:           metrics,
+	}
+	if err := addPeers(g.allowedPeers, cfg.AllowedPeers); err != nil {
+		return nil, err
+	}
+	if err := addPeers(g.deniedPeers, cfg.DeniedPeers); err != nil {
+		return nil, err
+	}
+	if err := addCIDRs(g.allowedCIDRs, cfg.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if err := addCIDRs(g.deniedCIDRs, cfg.DeniedCIDRs); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetNetwork sets the network that is used to count existing connections, limits are not applied until it was set
+func (g *Gater) SetNetwork(n network.Network) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.net = n
+}
+
+// Config returns the current config of the gater
+func (g *Gater) Config() config.GaterConfig {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	return config.GaterConfig{
+		AllowedPeers:      peerStrings(g.allowedPeers),
+		DeniedPeers:       peerStrings(g.deniedPeers),
+		AllowedCIDRs:      cidrStrings(g.allowedCIDRs),
+		DeniedCIDRs:       cidrStrings(g.deniedCIDRs),
+		MaxConnsPerIP:     g.maxConnsPerIP,
+		MaxConnsPerSubnet: g.maxConnsPerSubnet,
+	}
+}
+
+// AllowPeer adds the given peer to the allow list, once the list is not empty only listed peers are allowed
+func (g *Gater) AllowPeer(pid peer.ID) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.allowedPeers[pid] = struct{}{}
+}
+
+// DisallowPeer removes the given peer from the allow list
+func (g *Gater) DisallowPeer(pid peer.ID) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	delete(g.allowedPeers, pid)
+}
+
+// DenyPeer adds the given peer to the deny list, existing connections are not closed
+func (g *Gater) DenyPeer(pid peer.ID) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.deniedPeers[pid] = struct{}{}
+}
+
+// UndenyPeer removes the given peer from the deny list
+func (g *Gater) UndenyPeer(pid peer.ID) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	delete(g.deniedPeers, pid)
+}
+
+// AllowCIDR adds the given subnet to the allow list, once the list is not empty only addresses in listed subnets are allowed
+func (g *Gater) AllowCIDR(cidr string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return addCIDRs(g.allowedCIDRs, []string{cidr})
+}
+
+// DisallowCIDR removes the given subnet from the allow list
+func (g *Gater) DisallowCIDR(cidr string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return removeCIDR(g.allowedCIDRs, cidr)
+}
+
+// DenyCIDR adds the given subnet to the deny list, existing connections are not closed
+func (g *Gater) DenyCIDR(cidr string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return addCIDRs(g.deniedCIDRs, []string{cidr})
+}
+
+// UndenyCIDR removes the given subnet from the deny list
+func (g *Gater) UndenyCIDR(cidr string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return removeCIDR(g.deniedCIDRs, cidr)
+}
+
+// SetLimits sets the max number of inbound connections per IP and subnet, zero means unlimited
+func (g *Gater) SetLimits(maxConnsPerIP, maxConnsPerSubnet int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.maxConnsPerIP = maxConnsPerIP
+	g.maxConnsPerSubnet = maxConnsPerSubnet
+}
+
+// InterceptPeerDial implements connmgr.ConnectionGater
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	return g.check(network.DirOutbound, func() string {
+		return g.checkPeer(p)
+	})
+}
+
+// InterceptAddrDial implements connmgr.ConnectionGater
+func (g *Gater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) bool {
+	return g.check(network.DirOutbound, func() string {
+		return g.checkAddr(addr)
+	})
+}
+
+// InterceptAccept implements connmgr.ConnectionGater
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.check(network.DirInbound, func() string {
+		if reason := g.checkAddr(addrs.RemoteMultiaddr()); len(reason) > 0 {
+			return reason
+		}
+		return g.checkLimits(addrs.RemoteMultiaddr())
+	})
+}
+
+// InterceptSecured implements connmgr.ConnectionGater
+func (g *Gater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if dir == network.DirOutbound {
+		// already checked in InterceptPeerDial
+		return true
+	}
+	return g.check(dir, func() string {
+		return g.checkPeer(p)
+	})
+}
+
+// InterceptUpgraded implements connmgr.ConnectionGater
+func (g *Gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// check runs the given check and reports rejections
+func (g *Gater) check(dir network.Direction, checkFn func() string) bool {
+	g.lock.RLock()
+	reason := checkFn()
+	g.lock.RUnlock()
+
+	if len(reason) == 0 {
+		return true
+	}
+	logger.Debugf("rejected %s connection: %s", dir.String(), reason)
+	g.metrics.rejected.WithLabelValues(directionLabel(dir), reason).Inc()
+	return false
+}
+
+// checkPeer returns the reason to reject the given peer, or an empty string if the peer is allowed.
+// The caller must hold the lock.
+func (g *Gater) checkPeer(p peer.ID) string {
+	if _, ok := g.deniedPeers[p]; ok {
+		return reasonDeniedPeer
+	}
+	if len(g.allowedPeers) > 0 {
+		if _, ok := g.allowedPeers[p]; !ok {
+			return reasonNotAllowedPeer
+		}
+	}
+	return ""
+}
+
+// checkAddr returns the reason to reject the given address, or an empty string if the address is allowed.
+// Addresses without IP (e.g. relay addresses) are allowed. The caller must hold the lock.
+func (g *Gater) checkAddr(addr ma.Multiaddr) string {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return ""
+	}
+	for _, ipnet := range g.deniedCIDRs {
+		if ipnet.Contains(ip) {
+			return reasonDeniedAddr
+		}
+	}
+	if len(g.allowedCIDRs) == 0 {
+		return ""
+	}
+	for _, ipnet := range g.allowedCIDRs {
+		if ipnet.Contains(ip) {
+			return ""
+		}
+	}
+	return reasonNotAllowedAddr
+}
+
+// checkLimits returns the reason to reject a new connection from the given address,
+// or an empty string if the limits were not reached. The caller must hold the lock.
+func (g *Gater) checkLimits(addr ma.Multiaddr) string {
+	if g.net == nil || (g.maxConnsPerIP <= 0 && g.maxConnsPerSubnet <= 0) {
+		return ""
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return ""
+	}
+	subnet := subnetOf(ip)
+	var ipConns, subnetConns int
+	for _, c := range g.net.Conns() {
+		remoteIP, err := manet.ToIP(c.RemoteMultiaddr())
+		if err != nil {
+			continue
+		}
+		if remoteIP.Equal(ip) {
+			ipConns++
+		}
+		if subnet.Contains(remoteIP) {
+			subnetConns++
+		}
+	}
+	if g.maxConnsPerIP > 0 && ipConns >= g.maxConnsPerIP {
+		return reasonIPLimit
+	}
+	if g.maxConnsPerSubnet > 0 && subnetConns >= g.maxConnsPerSubnet {
+		return reasonSubnetLimit
+	}
+	return ""
+}
+
+// subnetOf returns the subnet of the given ip
+func subnetOf(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(ipv4SubnetBits, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(ipv6SubnetBits, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// directionLabel returns the metrics label of the given direction
+func directionLabel(dir network.Direction) string {
+	if dir == network.DirOutbound {
+		return "dial"
+	}
+	return "accept"
+}
+
+// addPeers decodes the given peer IDs and adds them to the given set
+func addPeers(peers map[peer.ID]struct{}, ids []string) error {
+	for _, id := range ids {
+		pid, err := peer.Decode(id)
+		if err != nil {
+			return errors.Wrapf(err, "invalid peer id %s", id)
+		}
+		peers[pid] = struct{}{}
+	}
+	return nil
+}
+
+// addCIDRs parses the given subnets and adds them to the given set
+func addCIDRs(cidrs map[string]*net.IPNet, subnets []string) error {
+	for _, subnet := range subnets {
+		_, ipnet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return errors.Wrapf(err, "invalid cidr %s", subnet)
+		}
+		cidrs[ipnet.String()] = ipnet
+	}
+	return nil
+}
+
+// removeCIDR removes the given subnet from the given set
+func removeCIDR(cidrs map[string]*net.IPNet, subnet string) error {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return errors.Wrapf(err, "invalid cidr %s", subnet)
+	}
+	delete(cidrs, ipnet.String())
+	return nil
+}
+
+func peerStrings(peers map[peer.ID]struct{}) []string {
+	res := make([]string, 0, len(peers))
+	for pid := range peers {
+		res = append(res, pid.String())
+	}
+	return res
+}
+
+func cidrStrings(cidrs map[string]*net.IPNet) []string {
+	res := make([]string, 0, len(cidrs))
+	for cidr := range cidrs {
+		res = append(res, cidr)
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package gater
+
+import (
+	"net"
+	"testing"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type connAddrs struct {
+	remote ma.Multiaddr
+}
+
+func (ca connAddrs) LocalMultiaddr() ma.Multiaddr {
+	return ma.StringCast("/ip4/127.0.0.1/tcp/1000")
+}
+
+func (ca connAddrs) RemoteMultiaddr() ma.Multiaddr {
+	return ca.remote
+}
+
+func TestGater(t *testing.T) {
+	allowed, denied, other := newPeerID(t), newPeerID(t), newPeerID(t)
+	metrics := NewMetrics(commons.MetricsOpts{})
+
+	_, err := New(config.GaterConfig{DeniedPeers: []string{"invalid"}}, nil)
+	require.Error(t, err)
+	_, err = New(config.GaterConfig{DeniedCIDRs: []string{"10.0.0.0"}}, nil)
+	require.Error(t, err)
+
+	g, err := New(config.GaterConfig{
+		AllowedPeers: []string{allowed.String()},
+		DeniedPeers:  []string{denied.String()},
+		DeniedCIDRs:  []string{"10.0.0.0/8"},
+	}, metrics)
+	require.NoError(t, err)
+
+	require.True(t, g.InterceptPeerDial(allowed))
+	require.False(t, g.InterceptPeerDial(denied))
+	require.False(t, g.InterceptPeerDial(other))
+	require.False(t, g.InterceptSecured(network.DirInbound, other, connAddrs{}))
+	require.True(t, g.InterceptSecured(network.DirInbound, allowed, connAddrs{}))
+
+	require.True(t, g.InterceptAddrDial(allowed, ma.StringCast("/ip4/192.168.1.1/tcp/1000")))
+	require.False(t, g.InterceptAddrDial(allowed, ma.StringCast("/ip4/10.1.1.1/tcp/1000")))
+	require.False(t, g.InterceptAccept(connAddrs{ma.StringCast("/ip4/10.1.1.1/tcp/1000")}))
+	// addresses without ip are not filtered
+	require.True(t, g.InterceptAddrDial(allowed, ma.StringCast("/dns4/example.com/tcp/1000")))
+
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.rejected.WithLabelValues("dial", reasonNotAllowedPeer))+
+		testutil.ToFloat64(metrics.rejected.WithLabelValues("dial", reasonDeniedPeer)))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.rejected.WithLabelValues("accept", reasonNotAllowedPeer)))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.rejected.WithLabelValues("accept", reasonDeniedAddr)))
+
+	// runtime updates
+	g.DisallowPeer(allowed)
+	g.UndenyPeer(denied)
+	require.True(t, g.InterceptPeerDial(denied))
+	require.True(t, g.InterceptPeerDial(other))
+	g.DenyPeer(other)
+	require.False(t, g.InterceptPeerDial(other))
+	g.AllowPeer(allowed)
+	require.False(t, g.InterceptPeerDial(denied))
+
+	require.NoError(t, g.UndenyCIDR("10.0.0.0/8"))
+	require.True(t, g.InterceptAddrDial(allowed, ma.StringCast("/ip4/10.1.1.1/tcp/1000")))
+	require.NoError(t, g.AllowCIDR("192.168.1.0/24"))
+	require.True(t, g.InterceptAddrDial(allowed, ma.StringCast("/ip4/192.168.1.1/tcp/1000")))
+	require.False(t, g.InterceptAddrDial(allowed, ma.StringCast("/ip4/192.168.2.1/tcp/1000")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.rejected.WithLabelValues("dial", reasonNotAllowedAddr)))
+	require.NoError(t, g.DenyCIDR("192.168.1.128/25"))
+	require.False(t, g.InterceptAddrDial(allowed, ma.StringCast("/ip4/192.168.1.200/tcp/1000")))
+	require.Error(t, g.AllowCIDR("invalid"))
+	require.NoError(t, g.DisallowCIDR("192.168.1.0/24"))
+
+	g.SetLimits(1, 2)
+	require.Equal(t, config.GaterConfig{
+		AllowedPeers:      []string{allowed.String()},
+		DeniedPeers:       []string{other.String()},
+		AllowedCIDRs:      []string{},
+		DeniedCIDRs:       []string{"192.168.1.128/25"},
+		MaxConnsPerIP:     1,
+		MaxConnsPerSubnet: 2,
+	}, g.Config())
+}
+
+func TestSubnetOf(t *testing.T) {
+	require.Equal(t, "192.168.1.0/24", subnetOf(net.ParseIP("192.168.1.17")).String())
+	require.Equal(t, "2001:db8:1:2::/64", subnetOf(net.ParseIP("2001:db8:1:2:3::1")).String())
+}
+
+func newPeerID(t *testing.T) peer.ID {
+	_, pk, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	return pid
+}
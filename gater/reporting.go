@@ -0,0 +1,29 @@
+package gater
+
+import (
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsSubsystem = "gater"
+
+// Metrics contains the metrics of the connection gater
+type Metrics struct {
+	rejected *prometheus.CounterVec
+}
+
+// NewMetrics creates gater metrics with the given options
+func NewMetrics(opts commons.MetricsOpts) *Metrics {
+	return &Metrics{
+		rejected: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "rejected",
+			Help:        "Counts rejected dials and accepts",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"direction", "reason"})),
+	}
+}
+
+// defaultMetrics are used when no metrics were provided, they are not registered (no-op)
+var defaultMetrics = NewMetrics(commons.MetricsOpts{})
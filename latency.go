@@ -0,0 +1,111 @@
+package p2pfacade
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	// latencyDefaultConcurrency is the max number of concurrent pings if not configured
+	latencyDefaultConcurrency = 8
+	// latencyDefaultTimeout is the timeout of a single ping if not configured
+	latencyDefaultTimeout = 10 * time.Second
+)
+
+// latencyMonitor periodically pings connected peers,
+// the moving average of the latency is kept in the peerstore (see peerstore.Metrics)
+type latencyMonitor struct {
+	ctx     context.Context
+	host    host.Host
+	metrics *metrics
+	logger  logging.StandardLogger
+
+	interval    time.Duration
+	concurrency int
+	timeout     time.Duration
+}
+
+func newLatencyMonitor(ctx context.Context, h host.Host, metrics *metrics, logger logging.StandardLogger,
+	cfg config.LatencyConfig) *latencyMonitor {
+	lm := &latencyMonitor{
+		ctx:         ctx,
+		host:        h,
+		metrics:     metrics,
+		logger:      logger,
+		interval:    cfg.Interval,
+		concurrency: cfg.Concurrency,
+		timeout:     cfg.Timeout,
+	}
+	if lm.concurrency <= 0 {
+		lm.concurrency = latencyDefaultConcurrency
+	}
+	if lm.timeout <= 0 {
+		lm.timeout = latencyDefaultTimeout
+	}
+	return lm
+}
+
+// run pings connected peers every interval until the context is done
+func (lm *latencyMonitor) run() {
+	ticker := time.NewTicker(lm.interval)
+	defer ticker.Stop()
+	for {
+		lm.pingPeers()
+		select {
+		case <-ticker.C:
+		case <-lm.ctx.Done():
+			return
+		}
+	}
+}
+
+// pingPeers pings all connected peers, and waits for the pings to complete
+func (lm *latencyMonitor) pingPeers() {
+	sem := make(chan struct{}, lm.concurrency)
+	var wg sync.WaitGroup
+	for _, pid := range lm.host.Network().Peers() {
+		select {
+		case sem <- struct{}{}:
+		case <-lm.ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			defer func() {
+				<-sem
+			}()
+			lm.ping(pid)
+		}(pid)
+	}
+	wg.Wait()
+}
+
+// ping measures the latency to the given peer, the result is recorded in the peerstore by ping.Ping
+func (lm *latencyMonitor) ping(pid peer.ID) {
+	ctx, cancel := context.WithTimeout(lm.ctx, lm.timeout)
+	defer cancel()
+
+	res, ok := <-ping.Ping(ctx, lm.host, pid)
+	if !ok || res.Error != nil {
+		if lm.ctx.Err() == nil {
+			lm.logger.Debugf("could not ping peer %s: %v", pid.String(), res.Error)
+			lm.metrics.pingFailures.Inc()
+		}
+		return
+	}
+	lm.metrics.latency.Observe(res.RTT.Seconds())
+}
+
+// Latency implements Facade
+func (f *facade) Latency(pid peer.ID) time.Duration {
+	return f.host.Peerstore().LatencyEWMA(pid)
+}
@@ -0,0 +1,60 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	nodes := make([]Facade, 2)
+	for i := range nodes {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		if i == 0 {
+			cfg.Latency = config.LatencyConfig{Interval: 20 * time.Millisecond, Concurrency: 1}
+		}
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+	other := nodes[1].Host().ID()
+	require.Zero(t, nodes[0].Latency(other))
+
+	require.NoError(t, nodes[0].Host().Connect(ctx, *host.InfoFromHost(nodes[1].Host())))
+	require.Eventually(t, func() bool {
+		return nodes[0].Latency(other) > 0 && latencySamples(t, reg) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	pi, ok := nodes[0].PeerInfo(other)
+	require.True(t, ok)
+	require.Equal(t, nodes[0].Latency(other), pi.Latency)
+	// the monitor is disabled by default
+	require.Zero(t, nodes[1].Latency(nodes[0].Host().ID()))
+}
+
+// latencySamples returns the number of samples in the latency histogram
+func latencySamples(t *testing.T, reg prometheus.Gatherer) uint64 {
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() == "test_peer_latency_seconds" {
+			return mf.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package p2pfacade
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ReachabilityInfo contains the reachability of the node and its addresses
+type ReachabilityInfo struct {
+	// Reachability is the reachability of the node as detected by AutoNAT, or as configured (see config.NATConfig)
+	Reachability libp2pnetwork.Reachability
+	// Addrs are the addresses that the node advertises to other peers
+	Addrs []ma.Multiaddr
+	// ObservedAddrs are the addresses of the node as observed by other peers
+	ObservedAddrs []ma.Multiaddr
+}
+
+// natStatus tracks the reachability of the node
+type natStatus struct {
+	ids identify.IDService
+
+	lock         sync.RWMutex
+	reachability libp2pnetwork.Reachability
+}
+
+// newNATStatus creates a new natStatus, the identify service of the host is used for observed addresses if available
+func newNATStatus(h host.Host) *natStatus {
+	ns := &natStatus{reachability: libp2pnetwork.ReachabilityUnknown}
+	if idh, ok := h.(interface{ IDService() identify.IDService }); ok {
+		ns.ids = idh.IDService()
+	}
+	return ns
+}
+
+func (ns *natStatus) setReachability(r libp2pnetwork.Reachability) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	ns.reachability = r
+}
+
+func (ns *natStatus) getReachability() libp2pnetwork.Reachability {
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+
+	return ns.reachability
+}
+
+// observedAddrs returns our addresses as observed by other peers
+func (ns *natStatus) observedAddrs() []ma.Multiaddr {
+	if ns.ids == nil {
+		return nil
+	}
+	return ns.ids.OwnObservedAddrs()
+}
+
+// Reachability implements Facade
+func (f *facade) Reachability() ReachabilityInfo {
+	return ReachabilityInfo{
+		Reachability:  f.nat.getReachability(),
+		Addrs:         f.host.Addrs(),
+		ObservedAddrs: f.nat.observedAddrs(),
+	}
+}
+
+// reportReachability updates the reachability metrics
+func (f *facade) reportReachability(r libp2pnetwork.Reachability) {
+	for _, reachability := range []libp2pnetwork.Reachability{
+		libp2pnetwork.ReachabilityUnknown, libp2pnetwork.ReachabilityPublic, libp2pnetwork.ReachabilityPrivate,
+	} {
+		val := 0.0
+		if reachability == r {
+			val = 1.0
+		}
+		f.metrics.reachability.WithLabelValues(reachability.String()).Set(val)
+	}
+}
@@ -0,0 +1,60 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReachability(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	cfg := &config.Config{}
+	cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	cfg.NAT = config.NATConfig{AutoNATService: true, HolePunching: true, Reachability: config.ReachabilityPublic}
+	f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	require.NoError(t, f.Start(nil))
+
+	// forced reachability is reported once the host event was handled
+	require.Eventually(t, func() bool {
+		return f.Reachability().Reachability == network.ReachabilityPublic
+	}, 5*time.Second, 10*time.Millisecond)
+	info := f.Reachability()
+	require.Equal(t, f.Host().Addrs(), info.Addrs)
+	require.Empty(t, info.ObservedAddrs)
+	require.Equal(t, 1.0, gatherGauge(t, reg, "test_reachability", "reachability", network.ReachabilityPublic.String()))
+	require.Equal(t, 0.0, gatherGauge(t, reg, "test_reachability", "reachability", network.ReachabilityUnknown.String()))
+
+	cfg = &config.Config{}
+	cfg.NAT.Reachability = "unknown"
+	_, err = New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+	require.Error(t, err)
+}
+
+// gatherGauge returns the value of the given gauge, with the given label value
+func gatherGauge(t *testing.T, reg prometheus.Gatherer, name, label, value string) float64 {
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if hasLabel(m, label, value) {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	return -1
+}
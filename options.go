@@ -0,0 +1,186 @@
+package p2pfacade
+
+import (
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/routing"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Option configures the facade, options are applied by the given order.
+//
+// The config (see WithConfig) is always initialized and translated into libp2p options,
+// other options are composed with it according to the following rules:
+//   - libp2p options (see WithLibp2pOptions) are appended after the options of the config
+//   - pubsub configurer and routing (see WithPubsubConfigurer, WithRouting) take precedence over the config
+//   - a given host (see WithHost) is used as is, the config is not translated into libp2p options in that case
+type Option func(opts *options) error
+
+type options struct {
+	cfg              *config.Config
+	host             host.Host
+	libp2pOpts       []libp2p.Option
+	pubsubConfigurer config.PubsubConfigurer
+	routing          func(h host.Host) (routing.Routing, error)
+	backoff          libp2pdisc.BackoffFactory
+	discoverers      []Discoverer
+	resolver         Resolver
+	metrics          commons.MetricsOpts
+	logger           logging.StandardLogger
+}
+
+// applyOptions applies the given options on top of the defaults
+func applyOptions(opts ...Option) (*options, error) {
+	o := &options{
+		metrics:  commons.DefaultMetricsOpts(),
+		logger:   logger,
+		resolver: madns.DefaultResolver,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, errors.Wrap(err, "could not apply option")
+		}
+	}
+	if o.cfg == nil {
+		o.cfg = &config.Config{}
+	}
+	if o.pubsubConfigurer == nil {
+		o.pubsubConfigurer = o.cfg.PubsubConfigurer
+	}
+	if o.routing == nil {
+		o.routing = o.cfg.Routing
+	}
+	return o, nil
+}
+
+// WithConfig sets the config of the facade, an empty config is used if not provided.
+// If used multiple times, the last config wins.
+func WithConfig(cfg *config.Config) Option {
+	return func(opts *options) error {
+		if cfg == nil {
+			return errors.New("nil config")
+		}
+		opts.cfg = cfg
+		return nil
+	}
+}
+
+// WithLibp2pOptions appends the given libp2p options after the options of the config.
+// If used multiple times, the options are accumulated.
+func WithLibp2pOptions(libp2pOpts ...libp2p.Option) Option {
+	return func(opts *options) error {
+		opts.libp2pOpts = append(opts.libp2pOpts, libp2pOpts...)
+		return nil
+	}
+}
+
+// WithHost sets an existing host to use instead of creating a new one, e.g. a mocknet host.
+// The host is owned by the facade from that point and will be closed with it.
+func WithHost(h host.Host) Option {
+	return func(opts *options) error {
+		if h == nil {
+			return errors.New("nil host")
+		}
+		opts.host = h
+		return nil
+	}
+}
+
+// WithPubsubConfigurer sets the pubsub configurer, overrides the one in config
+func WithPubsubConfigurer(configurer config.PubsubConfigurer) Option {
+	return func(opts *options) error {
+		opts.pubsubConfigurer = configurer
+		return nil
+	}
+}
+
+// WithRouting sets the routing constructor, overrides the one in config
+func WithRouting(r func(h host.Host) (routing.Routing, error)) Option {
+	return func(opts *options) error {
+		opts.routing = r
+		return nil
+	}
+}
+
+// WithConnectorBackoff sets the backoff used by the connector between attempts to connect the same peer,
+// an exponential backoff with jitter is used by default.
+func WithConnectorBackoff(backoff libp2pdisc.BackoffFactory) Option {
+	return func(opts *options) error {
+		if backoff == nil {
+			return errors.New("nil backoff")
+		}
+		opts.backoff = backoff
+		return nil
+	}
+}
+
+// WithDiscoverers adds custom sources of peers to connect, in addition to mdns and routing (see config.DiscoveryConfig).
+// If used multiple times, the discoverers are accumulated.
+func WithDiscoverers(ds ...Discoverer) Option {
+	return func(opts *options) error {
+		for _, d := range ds {
+			if d == nil {
+				return errors.New("nil discoverer")
+			}
+		}
+		opts.discoverers = append(opts.discoverers, ds...)
+		return nil
+	}
+}
+
+// WithResolver sets the resolver of DNS multiaddrs of bootstrap peers and relayers, madns.DefaultResolver is used by default
+func WithResolver(r Resolver) Option {
+	return func(opts *options) error {
+		if r == nil {
+			return errors.New("nil resolver")
+		}
+		opts.resolver = r
+		return nil
+	}
+}
+
+// WithMetricsRegistry sets the registerer of the facade metrics, prometheus.DefaultRegisterer is used by default.
+// Metrics will not be registered (no-op) if the given registerer is nil.
+// Facades that share a registerer must use different namespaces or labels (see WithMetricsLabels),
+// the metrics of a facade are unregistered once it is closed.
+func WithMetricsRegistry(reg prometheus.Registerer) Option {
+	return func(opts *options) error {
+		opts.metrics.Registerer = reg
+		return nil
+	}
+}
+
+// WithMetricsNamespace sets the namespace of the facade metrics, commons.DefaultMetricsNamespace is used by default
+func WithMetricsNamespace(ns string) Option {
+	return func(opts *options) error {
+		opts.metrics.Namespace = ns
+		return nil
+	}
+}
+
+// WithMetricsLabels sets constant labels that will be added to all the facade metrics,
+// it can be used to distinguish between multiple facades that share a registry.
+func WithMetricsLabels(labels prometheus.Labels) Option {
+	return func(opts *options) error {
+		opts.metrics.ConstLabels = labels
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the facade and its components.
+// Exported discoverers and helpers (e.g. ResolvePeers, Notiffee) keep logging with the p2p:conn logger.
+func WithLogger(l logging.StandardLogger) Option {
+	return func(opts *options) error {
+		if l == nil {
+			return errors.New("nil logger")
+		}
+		opts.logger = l
+		return nil
+	}
+}
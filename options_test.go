@@ -0,0 +1,138 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newCfg := func() *config.Config {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		return cfg
+	}
+	newRouting := func(h host.Host) (routing.Routing, error) {
+		kad, _, err := NewKadDHT(ctx, h, "test.dht", dht.ModeServer, nil)
+		return kad, err
+	}
+
+	t.Run("no options", func(t *testing.T) {
+		f, err := New(ctx)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, f.Close())
+		}()
+		require.NotNil(t, f.Host())
+		require.Nil(t, f.Routing())
+		require.Nil(t, f.Pubsub())
+	})
+
+	t.Run("config", func(t *testing.T) {
+		cfg := newCfg()
+		cfg.PubsubConfigurer = pubsub.NewNilConfigurer()
+		cfg.Routing = newRouting
+		f, err := New(ctx, WithConfig(cfg))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, f.Close())
+		}()
+		require.NotNil(t, cfg.PrivateKey, "config should be initialized")
+		require.Equal(t, cfg.PrivateKey.GetPublic(), f.Host().Peerstore().PubKey(f.Host().ID()))
+		require.NotNil(t, f.Routing())
+		require.NotNil(t, f.Pubsub())
+	})
+
+	t.Run("last config wins", func(t *testing.T) {
+		cfg1, cfg2 := newCfg(), newCfg()
+		f, err := New(ctx, WithConfig(cfg1), WithConfig(cfg2))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, f.Close())
+		}()
+		require.Nil(t, cfg1.PrivateKey)
+		require.Equal(t, cfg2.PrivateKey.GetPublic(), f.Host().Peerstore().PubKey(f.Host().ID()))
+	})
+
+	t.Run("libp2p options are appended to config", func(t *testing.T) {
+		cfg := newCfg()
+		cfg.UserAgent = "test/config"
+		f, err := New(ctx, WithConfig(cfg), WithLibp2pOptions(libp2p.UserAgent("test/option")))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, f.Close())
+		}()
+		// config options are kept
+		require.Equal(t, cfg.PrivateKey.GetPublic(), f.Host().Peerstore().PubKey(f.Host().ID()))
+
+		other, err := New(ctx, WithConfig(newCfg()))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, other.Close())
+		}()
+		require.NoError(t, other.Host().Connect(ctx, *host.InfoFromHost(f.Host())))
+		require.Eventually(t, func() bool {
+			av, err := other.Host().Peerstore().Get(f.Host().ID(), "AgentVersion")
+			return err == nil && av == "test/option"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("components override config", func(t *testing.T) {
+		cfg := newCfg()
+		f, err := New(ctx, WithConfig(cfg), WithPubsubConfigurer(pubsub.NewNilConfigurer()), WithRouting(newRouting))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, f.Close())
+		}()
+		require.NotNil(t, f.Routing())
+		require.NotNil(t, f.Pubsub())
+		// config was not changed
+		require.Nil(t, cfg.Routing)
+		require.Nil(t, cfg.PubsubConfigurer)
+	})
+
+	t.Run("logger", func(t *testing.T) {
+		core, logs := observer.New(zapcore.DebugLevel)
+		f, err := New(ctx, WithConfig(newCfg()), WithLogger(zap.New(core).Sugar()))
+		require.NoError(t, err)
+		// the components of the facade log with its logger
+		other, err := New(ctx, WithConfig(newCfg()))
+		require.NoError(t, err)
+		require.NoError(t, f.Host().Connect(ctx, *host.InfoFromHost(other.Host())))
+		require.Eventually(t, func() bool {
+			return logs.FilterMessageSnippet("new connected peer").Len() > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, other.Close())
+		require.NoError(t, f.Close())
+		require.Greater(t, logs.FilterMessage("libp2p facade was created successfully").Len(), 0)
+		require.Greater(t, logs.FilterMessage("libp2p facade was closed").Len(), 0)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := New(ctx, WithConfig(nil))
+		require.Error(t, err)
+		_, err = New(ctx, WithLogger(nil))
+		require.Error(t, err)
+		_, err = New(ctx, WithConnectorBackoff(nil))
+		require.Error(t, err)
+		_, err = New(ctx, func(opts *options) error {
+			return errors.New("test error")
+		})
+		require.Error(t, err)
+	})
+}
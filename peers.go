@@ -0,0 +1,204 @@
+package p2pfacade
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PeerSource is the way a peer was discovered
+type PeerSource string
+
+const (
+	// PeerSourceUnknown is used for peers that were not connected by the facade, e.g. inbound connections
+	PeerSourceUnknown PeerSource = ""
+	// PeerSourceMdns is used for peers that were found with mdns
+	PeerSourceMdns PeerSource = "mdns"
+	// PeerSourceConnectQueue is used for peers that were sent on the connect queue of Start
+	PeerSourceConnectQueue PeerSource = "connect_queue"
+	// PeerSourceScheduled is used for peers that were scheduled with ScheduleConnect
+	PeerSourceScheduled PeerSource = "scheduled"
+	// PeerSourceBootstrap is used for bootstrap peers (see config.StaticConfig.BootstrapPeers)
+	PeerSourceBootstrap PeerSource = "bootstrap"
+	// PeerSourceStatic is used for static peers (see config.StaticConfig.StaticPeers)
+	PeerSourceStatic PeerSource = "static"
+	// PeerSourcePeerstore is used for known peers that were loaded from the data directory
+	PeerSourcePeerstore PeerSource = "peerstore"
+	// PeerSourceRouting is used for peers that were found in the namespace of the routing (see config.DiscoveryConfig)
+	PeerSourceRouting PeerSource = "routing"
+	// PeerSourceRendezvous is used for peers that were found in rendezvous points (see config.RendezvousConfig)
+	PeerSourceRendezvous PeerSource = "rendezvous"
+	// PeerSourcePeerExchange is used for peers that were received from connected peers (see config.PeerExchangeConfig)
+	PeerSourcePeerExchange PeerSource = "px"
+	// PeerSourceTopicDiscovery is used for peers that were found for a topic we joined (see config.TopicDiscoveryConfig)
+	PeerSourceTopicDiscovery PeerSource = "topic_discovery"
+)
+
+// PeerInfo contains the information we have on a connected peer
+type PeerInfo struct {
+	ID peer.ID
+	// Direction is the direction of the oldest connection to the peer
+	Direction libp2pnetwork.Direction
+	// ConnectedAt is the time when the peer was connected
+	ConnectedAt time.Time
+	// Addrs are the remote addresses of the connections to the peer
+	Addrs []ma.Multiaddr
+	// Transport is the transport of the oldest connection to the peer (e.g. tcp, quic, ws)
+	Transport string
+	// AgentVersion and Protocols are reported by the peer with identify
+	AgentVersion string
+	Protocols    []string
+	// Latency is the last measured latency (EWMA), zero if it was not measured yet
+	Latency time.Duration
+	// Topics are the topics we joined that the peer is subscribed to
+	Topics []string
+	Source PeerSource
+}
+
+// Peers implements Facade
+func (f *facade) Peers() []PeerInfo {
+	pids := f.host.Network().Peers()
+	infos := make([]PeerInfo, 0, len(pids))
+	topicPeers := f.topicPeers()
+	for _, pid := range pids {
+		if pi, ok := f.peerInfo(pid, topicPeers); ok {
+			infos = append(infos, pi)
+		}
+	}
+	return infos
+}
+
+// PeerInfo implements Facade
+func (f *facade) PeerInfo(pid peer.ID) (PeerInfo, bool) {
+	return f.peerInfo(pid, f.topicPeers())
+}
+
+// peerInfo collects the information on the given peer, returns false if the peer is not connected
+func (f *facade) peerInfo(pid peer.ID, topicPeers map[string]map[peer.ID]struct{}) (PeerInfo, bool) {
+	conns := f.host.Network().ConnsToPeer(pid)
+	if len(conns) == 0 {
+		return PeerInfo{}, false
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Stat().Opened.Before(conns[j].Stat().Opened)
+	})
+	connectedAt, source := f.peers.get(pid)
+	pi := PeerInfo{
+		ID:          pid,
+		Direction:   conns[0].Stat().Direction,
+		ConnectedAt: connectedAt,
+		Transport:   transportOf(conns[0].RemoteMultiaddr()),
+		Latency:     f.host.Peerstore().LatencyEWMA(pid),
+		Source:      source,
+	}
+	for _, c := range conns {
+		pi.Addrs = mergeAddrs(pi.Addrs, []ma.Multiaddr{c.RemoteMultiaddr()})
+	}
+	identified := f.peerIdentified(pid)
+	pi.AgentVersion = identified.AgentVersion
+	pi.Protocols = identified.Protocols
+	for topicName, peers := range topicPeers {
+		if _, ok := peers[pid]; ok {
+			pi.Topics = append(pi.Topics, topicName)
+		}
+	}
+	sort.Strings(pi.Topics)
+	return pi, true
+}
+
+// topicPeers returns the subscribed peers of each topic we joined
+func (f *facade) topicPeers() map[string]map[peer.ID]struct{} {
+	res := make(map[string]map[peer.ID]struct{})
+	ps := f.Pubsub()
+	if ps == nil {
+		return res
+	}
+	for _, topicName := range ps.GetTopics() {
+		peers := make(map[peer.ID]struct{})
+		for _, pid := range ps.ListPeers(topicName) {
+			peers[pid] = struct{}{}
+		}
+		res[topicName] = peers
+	}
+	return res
+}
+
+// transportOf returns the name of the transport of the given address, e.g. tcp, quic, ws
+func transportOf(addr ma.Multiaddr) string {
+	if addr == nil {
+		return ""
+	}
+	transport := ""
+	for _, p := range addr.Protocols() {
+		switch p.Code {
+		case ma.P_CIRCUIT:
+			// relayed connections are named after the relay, regardless of the transport to the relay
+			return p.Name
+		case ma.P_TCP, ma.P_UDP, ma.P_QUIC, ma.P_WS, ma.P_WSS:
+			transport = p.Name
+		}
+	}
+	return transport
+}
+
+// peerTracker keeps the connection time and the discovery source of connected peers
+type peerTracker struct {
+	lock      sync.RWMutex
+	connected map[peer.ID]time.Time
+	sources   map[peer.ID]PeerSource
+}
+
+func newPeerTracker() *peerTracker {
+	return &peerTracker{
+		connected: make(map[peer.ID]time.Time),
+		sources:   make(map[peer.ID]PeerSource),
+	}
+}
+
+// setSource sets the source of the given peer, once it was connected.
+// It is ignored if the peer was disconnected meanwhile, so a stale source is not kept after the disconnection
+func (pt *peerTracker) setSource(pid peer.ID, source PeerSource) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	if _, ok := pt.connected[pid]; !ok {
+		return
+	}
+	pt.sources[pid] = source
+}
+
+// get returns the connection time and the source of the given peer
+func (pt *peerTracker) get(pid peer.ID) (time.Time, PeerSource) {
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+
+	return pt.connected[pid], pt.sources[pid]
+}
+
+// notifiee returns a notify bundle that tracks the connection time of peers,
+// and removes the tracked information once they are disconnected
+func (pt *peerTracker) notifiee() *libp2pnetwork.NotifyBundle {
+	return &libp2pnetwork.NotifyBundle{
+		ConnectedF: func(n libp2pnetwork.Network, c libp2pnetwork.Conn) {
+			pt.lock.Lock()
+			defer pt.lock.Unlock()
+
+			if _, ok := pt.connected[c.RemotePeer()]; !ok {
+				pt.connected[c.RemotePeer()] = time.Now()
+			}
+		},
+		DisconnectedF: func(n libp2pnetwork.Network, c libp2pnetwork.Conn) {
+			pt.lock.Lock()
+			defer pt.lock.Unlock()
+
+			if n.Connectedness(c.RemotePeer()) != libp2pnetwork.Connected {
+				delete(pt.connected, c.RemotePeer())
+				delete(pt.sources, c.RemotePeer())
+			}
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package p2pfacade_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/amirylm/libp2p-facade/facadetest"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cluster, err := facadetest.NewCluster(ctx, facadetest.ClusterConfig{
+		N: 3,
+		Topology: func(n int) []facadetest.Link {
+			return []facadetest.Link{{0, 1}}
+		},
+		Options: func(i int) []p2pfacade.Option {
+			return []p2pfacade.Option{p2pfacade.WithPubsubConfigurer(facadetest.NewPubsubConfigurer())}
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, cluster.Close())
+	}()
+
+	topicName := "peers"
+	for _, f := range cluster.Nodes[:2] {
+		require.NoError(t, f.Subscribe(topicName, func(*pubsub.Message) {}, 10))
+	}
+	require.NoError(t, facadetest.WaitFor(ctx, func() bool {
+		pi, ok := cluster.Nodes[0].PeerInfo(cluster.ID(1))
+		return ok && len(pi.Topics) == 1
+	}))
+
+	pi, ok := cluster.Nodes[0].PeerInfo(cluster.ID(1))
+	require.True(t, ok)
+	require.Equal(t, cluster.ID(1), pi.ID)
+	require.Equal(t, libp2pnetwork.DirOutbound, pi.Direction)
+	require.False(t, pi.ConnectedAt.IsZero())
+	require.Equal(t, cluster.AddrInfo(1).Addrs, pi.Addrs)
+	require.Equal(t, "tcp", pi.Transport)
+	require.Contains(t, pi.Protocols, "/ipfs/id/1.0.0")
+	require.Equal(t, []string{topicName}, pi.Topics)
+	require.Equal(t, p2pfacade.PeerSourceUnknown, pi.Source)
+	pi, ok = cluster.Nodes[1].PeerInfo(cluster.ID(0))
+	require.True(t, ok)
+	require.Equal(t, libp2pnetwork.DirInbound, pi.Direction)
+
+	_, ok = cluster.Nodes[0].PeerInfo(cluster.ID(2))
+	require.False(t, ok)
+	require.Len(t, cluster.Nodes[0].Peers(), 1)
+
+	// peers that were connected by the facade are reported with their source
+	_, err = cluster.Net.LinkPeers(cluster.ID(0), cluster.ID(2))
+	require.NoError(t, err)
+	_, err = cluster.Net.LinkPeers(cluster.ID(1), cluster.ID(2))
+	require.NoError(t, err)
+	require.NoError(t, cluster.Discover(ctx, 0, 2))
+	require.True(t, cluster.Nodes[1].ScheduleConnect(cluster.AddrInfo(2), p2pfacade.ConnectPriorityHigh))
+	// the source is recorded once the connector was done connecting
+	require.NoError(t, facadetest.WaitFor(ctx, func() bool {
+		pi0, _ := cluster.Nodes[0].PeerInfo(cluster.ID(2))
+		pi1, _ := cluster.Nodes[1].PeerInfo(cluster.ID(2))
+		return pi0.Source == p2pfacade.PeerSourceConnectQueue && pi1.Source == p2pfacade.PeerSourceScheduled
+	}))
+	pi, _ = cluster.Nodes[0].PeerInfo(cluster.ID(2))
+	require.Empty(t, pi.Topics)
+	require.Len(t, cluster.Nodes[0].Peers(), 2)
+}
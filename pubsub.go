@@ -1,44 +1,89 @@
 package p2pfacade
 
 import (
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
 	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/libp2p/go-libp2p-core/peer"
 	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/pkg/errors"
 )
 
-func (f *facade) setupPubsub() error {
-	if f.cfg.PubsubConfigurer == nil {
+// ErrPubsubDisabled is returned when using pubsub while it was not configured
+var ErrPubsubDisabled = errors.New("pubsub is disabled")
+
+func (f *facade) setupPubsub(configurer config.PubsubConfigurer, metricsOpts commons.MetricsOpts) error {
+	if configurer == nil {
 		return nil
 	}
+	metrics := pubsub.NewMetrics(metricsOpts)
+	f.mesh = pubsub.NewMeshTracer(f.host.ConnManager())
 	opts := make([]pubsublibp2p.Option, 0)
-	opts = append(opts, pubsublibp2p.WithEventTracer(pubsub.NewReportingTracer()))
-	opts = append(opts, f.cfg.PubsubConfigurer.Opts()...)
+	opts = append(opts, pubsublibp2p.WithEventTracer(pubsub.NewReportingTracer(metrics)))
+	opts = append(opts, pubsublibp2p.WithRawTracer(f.mesh))
+	opts = append(opts, configurer.Opts()...)
 	ps, err := pubsublibp2p.NewGossipSub(f.ctx, f.host, opts...)
 	if err != nil {
 		return errors.Wrap(err, "could not setup pubsub")
 	}
-	f.ps = pubsub.NewPubsubService(f.ctx, ps, f.cfg.PubsubConfigurer)
+	f.ps = pubsub.NewPubsubService(f.ctx, ps, configurer, metrics, f.events)
 
 	return nil
 }
 
 // GetSubscription implements Facade
 func (f *facade) GetSubscription(topicName string) *pubsublibp2p.Subscription {
+	if f.ps == nil {
+		return nil
+	}
 	return f.ps.GetSubscription(topicName)
 }
 
 // GetTopic implements Facade
 func (f *facade) GetTopic(topicName string) *pubsublibp2p.Topic {
+	if f.ps == nil {
+		return nil
+	}
 	return f.ps.GetTopic(topicName)
 }
 
 // Pubsub implements Facade
 func (f *facade) Pubsub() *pubsublibp2p.PubSub {
+	if f.ps == nil {
+		return nil
+	}
 	return f.ps.Pubsub()
 }
 
+// Topics implements Facade
+func (f *facade) Topics() []string {
+	if f.ps == nil {
+		return nil
+	}
+	return f.ps.Topics()
+}
+
+// SubscriptionStats implements Facade
+func (f *facade) SubscriptionStats(topicName string) (pubsub.SubscriptionStats, bool) {
+	if f.ps == nil {
+		return pubsub.SubscriptionStats{}, false
+	}
+	return f.ps.SubscriptionStats(topicName)
+}
+
+// MeshPeers implements Facade
+func (f *facade) MeshPeers(topicName string) []peer.ID {
+	if f.mesh == nil {
+		return nil
+	}
+	return f.mesh.MeshPeers(topicName)
+}
+
 // Publish implements Facade
 func (f *facade) Publish(topicName string, data []byte) error {
+	if f.ps == nil {
+		return ErrPubsubDisabled
+	}
 	return f.ps.Publish(topicName, data)
 }
 
@@ -51,10 +96,16 @@ func (f *facade) Subscribe(topicName string, handler pubsub.PubsubHandler, buffe
 	// 		bufferSize = topicCfg.BufferSize
 	// 	}
 	// }
+	if f.ps == nil {
+		return ErrPubsubDisabled
+	}
 	return f.ps.Subscribe(topicName, handler, bufferSize)
 }
 
 // UnSubscribe implements Facade
 func (f *facade) UnSubscribe(topicName string) error {
+	if f.ps == nil {
+		return ErrPubsubDisabled
+	}
 	return f.ps.UnSubscribe(topicName)
 }
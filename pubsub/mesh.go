@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// MeshTracer tracks the mesh peers of every topic (gossipsub), implements pubsublibp2p.RawTracer.
+// Mesh peers are protected in the given connection manager, so they won't be trimmed.
+type MeshTracer struct {
+	lock *sync.RWMutex
+	mesh map[string]map[peer.ID]struct{}
+	cm   connmgr.ConnManager
+}
+
+// NewMeshTracer creates a new MeshTracer, the connection manager is optional
+func NewMeshTracer(cm connmgr.ConnManager) *MeshTracer {
+	if cm == nil {
+		cm = &connmgr.NullConnMgr{}
+	}
+	return &MeshTracer{
+		lock: &sync.RWMutex{},
+		mesh: make(map[string]map[peer.ID]struct{}),
+		cm:   cm,
+	}
+}
+
+// MeshProtectionTag returns the tag that is used to protect the mesh peers of the given topic
+func MeshProtectionTag(topicName string) string {
+	return "pubsub:mesh:" + topicName
+}
+
+// MeshPeers returns the mesh peers of the given topic
+func (mt *MeshTracer) MeshPeers(topicName string) []peer.ID {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	peers := make([]peer.ID, 0, len(mt.mesh[topicName]))
+	for pid := range mt.mesh[topicName] {
+		peers = append(peers, pid)
+	}
+	return peers
+}
+
+// Graft implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) Graft(p peer.ID, topic string) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	peers, ok := mt.mesh[topic]
+	if !ok {
+		peers = make(map[peer.ID]struct{})
+		mt.mesh[topic] = peers
+	}
+	peers[p] = struct{}{}
+	mt.cm.Protect(p, MeshProtectionTag(topic))
+}
+
+// Prune implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) Prune(p peer.ID, topic string) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	delete(mt.mesh[topic], p)
+	mt.cm.Unprotect(p, MeshProtectionTag(topic))
+}
+
+// Leave implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) Leave(topic string) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	for pid := range mt.mesh[topic] {
+		mt.cm.Unprotect(pid, MeshProtectionTag(topic))
+	}
+	delete(mt.mesh, topic)
+}
+
+// RemovePeer implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) RemovePeer(p peer.ID) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	for topic, peers := range mt.mesh {
+		if _, ok := peers[p]; ok {
+			delete(peers, p)
+			mt.cm.Unprotect(p, MeshProtectionTag(topic))
+		}
+	}
+}
+
+// AddPeer implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) AddPeer(p peer.ID, proto protocol.ID) {}
+
+// Join implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) Join(topic string) {}
+
+// ValidateMessage implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) ValidateMessage(msg *pubsublibp2p.Message) {}
+
+// DeliverMessage implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) DeliverMessage(msg *pubsublibp2p.Message) {}
+
+// RejectMessage implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) RejectMessage(msg *pubsublibp2p.Message, reason string) {}
+
+// DuplicateMessage implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) DuplicateMessage(msg *pubsublibp2p.Message) {}
+
+// ThrottlePeer implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) ThrottlePeer(p peer.ID) {}
+
+// RecvRPC implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) RecvRPC(rpc *pubsublibp2p.RPC) {}
+
+// SendRPC implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) SendRPC(rpc *pubsublibp2p.RPC, p peer.ID) {}
+
+// DropRPC implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) DropRPC(rpc *pubsublibp2p.RPC, p peer.ID) {}
+
+// UndeliverableMessage implements pubsublibp2p.RawTracer
+func (mt *MeshTracer) UndeliverableMessage(msg *pubsublibp2p.Message) {}
@@ -1,36 +1,77 @@
 package pubsub
 
 import (
+	"github.com/amirylm/libp2p-facade/commons"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var (
-	metricPubsubListening = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "p2p_pubsub_topics",
-		Help: "Counts topics that we listen to",
-	}, []string{"topic"})
-	metricPubsubOut = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_pubsub_out",
-		Help: "Counts outgoing pubsub messages",
-	}, []string{"topic"})
-	metricPubsubIn = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_pubsub_in",
-		Help: "Counts incoming pubsub messages",
-	}, []string{"topic"})
-	metricPubsubInDropped = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_pubsub_in_dropped",
-		Help: "Counts incoming pubsub messages that were dropped",
-	}, []string{"topic"})
-	metricsPubsubTrace = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_pubsub_trace",
-		Help: "Tracks pubsub tracing events",
-	}, []string{"tp"})
-)
+const metricsSubsystem = "pubsub"
+
+// Metrics contains the metrics of pubsub
+type Metrics struct {
+	listening *prometheus.GaugeVec
+	out       *prometheus.CounterVec
+	in        *prometheus.CounterVec
+	inDropped *prometheus.CounterVec
+	outBytes  *prometheus.CounterVec
+	inBytes   *prometheus.CounterVec
+	trace     *prometheus.CounterVec
+}
 
-func init() {
-	_ = prometheus.Register(metricPubsubListening)
-	_ = prometheus.Register(metricPubsubOut)
-	_ = prometheus.Register(metricPubsubIn)
-	_ = prometheus.Register(metricPubsubInDropped)
+// NewMetrics creates pubsub metrics with the given options
+func NewMetrics(opts commons.MetricsOpts) *Metrics {
+	return &Metrics{
+		listening: commons.RegisterCollector(opts, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "topics",
+			Help:        "Counts topics that we listen to",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"topic"})),
+		out: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "out",
+			Help:        "Counts outgoing pubsub messages",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"topic"})),
+		in: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "in",
+			Help:        "Counts incoming pubsub messages",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"topic"})),
+		inDropped: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "in_dropped",
+			Help:        "Counts incoming pubsub messages that were dropped",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"topic"})),
+		outBytes: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "out_bytes",
+			Help:        "Counts payload bytes of outgoing pubsub messages",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"topic"})),
+		inBytes: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "in_bytes",
+			Help:        "Counts payload bytes of incoming pubsub messages, including dropped messages",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"topic"})),
+		trace: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "trace",
+			Help:        "Tracks pubsub tracing events",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"tp"})),
+	}
 }
+
+// defaultMetrics are used when no metrics were provided, they are not registered (no-op)
+var defaultMetrics = NewMetrics(commons.MetricsOpts{})
@@ -3,9 +3,11 @@ package pubsub
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
 	logging "github.com/ipfs/go-log/v2"
 	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/pkg/errors"
@@ -18,6 +20,8 @@ type PubsubService interface {
 	Publish(topicName string, data []byte) error
 	GetTopic(topicName string) *pubsublibp2p.Topic
 	GetSubscription(topicName string) *pubsublibp2p.Subscription
+	Topics() []string
+	SubscriptionStats(topicName string) (SubscriptionStats, bool)
 	UnSubscribe(topicName string) error
 	Subscribe(topicName string, handler PubsubHandler, bufferSize int) error
 }
@@ -33,26 +37,57 @@ var (
 
 type TopicConfigurer func(topic *pubsublibp2p.Topic)
 
+// SubscriptionStats are the stats of the messages queue of a subscription
+type SubscriptionStats struct {
+	Topic      string `json:"topic"`
+	BufferSize int    `json:"bufferSize"`
+	Queued     int    `json:"queued"`
+	Received   uint64 `json:"received"`
+	Dropped    uint64 `json:"dropped"`
+}
+
+// subscriptionQueue is the messages queue of a subscription
+type subscriptionQueue struct {
+	// counters are first to ensure 64-bit alignment
+	received uint64
+	dropped  uint64
+	receiver chan *pubsublibp2p.Message
+}
+
 type pubsubService struct {
 	ctx context.Context
 	ps  *pubsublibp2p.PubSub
 
-	topics map[string]*pubsublibp2p.Topic
-	subs   map[string]*pubsublibp2p.Subscription
-	lock   *sync.RWMutex
+	topics        map[string]*pubsublibp2p.Topic
+	subs          map[string]*pubsublibp2p.Subscription
+	queues        map[string]*subscriptionQueue
+	topicHandlers map[string]*topicHandler
+	lock          *sync.RWMutex
 
 	configurer config.PubsubConfigurer
+	metrics    *Metrics
+	emitter    events.Emitter
 }
 
-func NewPubsubService(ctx context.Context, ps *pubsublibp2p.PubSub, configurer config.PubsubConfigurer) PubsubService {
+// NewPubsubService creates a new pubsub service, no-op metrics are used if the given metrics are nil.
+// Topic events are emitted with the given emitter, if not nil.
+func NewPubsubService(ctx context.Context, ps *pubsublibp2p.PubSub, configurer config.PubsubConfigurer,
+	metrics *Metrics, emitter events.Emitter) PubsubService {
 	logger.Debug("creating pubsub service")
+	if metrics == nil {
+		metrics = defaultMetrics
+	}
 	return &pubsubService{
-		ctx:        ctx,
-		ps:         ps,
-		topics:     make(map[string]*pubsublibp2p.Topic),
-		subs:       make(map[string]*pubsublibp2p.Subscription),
-		lock:       &sync.RWMutex{},
-		configurer: configurer,
+		ctx:           ctx,
+		ps:            ps,
+		topics:        make(map[string]*pubsublibp2p.Topic),
+		subs:          make(map[string]*pubsublibp2p.Subscription),
+		queues:        make(map[string]*subscriptionQueue),
+		topicHandlers: make(map[string]*topicHandler),
+		lock:          &sync.RWMutex{},
+		configurer:    configurer,
+		metrics:       metrics,
+		emitter:       emitter,
 	}
 }
 
@@ -71,6 +106,34 @@ func (pst *pubsubService) GetTopic(topicName string) *pubsublibp2p.Topic {
 	return t
 }
 
+func (pst *pubsubService) Topics() []string {
+	pst.lock.RLock()
+	defer pst.lock.RUnlock()
+
+	names := make([]string, 0, len(pst.topics))
+	for name := range pst.topics {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (pst *pubsubService) SubscriptionStats(topicName string) (SubscriptionStats, bool) {
+	pst.lock.RLock()
+	defer pst.lock.RUnlock()
+
+	q, ok := pst.queues[topicName]
+	if !ok {
+		return SubscriptionStats{}, false
+	}
+	return SubscriptionStats{
+		Topic:      topicName,
+		BufferSize: cap(q.receiver),
+		Queued:     len(q.receiver),
+		Received:   atomic.LoadUint64(&q.received),
+		Dropped:    atomic.LoadUint64(&q.dropped),
+	}, true
+}
+
 func (pst *pubsubService) GetSubscription(topicName string) *pubsublibp2p.Subscription {
 	pst.lock.RLock()
 	defer pst.lock.RUnlock()
@@ -92,7 +155,8 @@ func (pst *pubsubService) Publish(topicName string, data []byte) error {
 	err := topic.Publish(fctx, data, pst.configurer.PubOpts(topicName)...)
 	if err == nil {
 		logger.Debugf("published msg on topic %s", topicName)
-		metricPubsubOut.WithLabelValues(topicName).Inc()
+		pst.metrics.out.WithLabelValues(topicName).Inc()
+		pst.metrics.outBytes.WithLabelValues(topicName).Add(float64(len(data)))
 	}
 	return err
 }
@@ -105,17 +169,23 @@ func (pst *pubsubService) UnSubscribe(topicName string) error {
 	if !ok {
 		return nil
 	}
-	s, ok := pst.subs[topicName]
-	if !ok {
-		return nil
+	// the subscription might have been dropped already, the topic is closed anyway
+	if s, ok := pst.subs[topicName]; ok {
+		s.Cancel()
 	}
-	s.Cancel()
+	pst.cancelTopicHandler(topicName)
 	err := topic.Close()
+	if pst.ctx.Err() != nil {
+		// pubsub was stopped with the context, its topics are closed anyway
+		err = nil
+	}
 
 	delete(pst.topics, topicName)
 	delete(pst.subs, topicName)
+	delete(pst.queues, topicName)
 
 	logger.Debugf("unsubsribed from topic %s", topicName)
+	pst.emit(events.TopicLeft{Topic: topicName})
 
 	return err
 }
@@ -128,6 +198,10 @@ func (pst *pubsubService) Subscribe(topicName string, handler PubsubHandler, buf
 	if err != nil {
 		return err
 	}
+	if sub == nil {
+		// already subscribed
+		return nil
+	}
 
 	cn := pst.listen(sub, bufferSize)
 
@@ -158,6 +232,10 @@ func (pst *pubsubService) subscribe(topicName string) (*pubsublibp2p.Subscriptio
 		pst.topics[topicName] = topic
 		t = topic
 		logger.Debugf("joined topic %s", topicName)
+		pst.emit(events.TopicJoined{Topic: topicName})
+		if err := pst.handleTopicEvents(topic); err != nil {
+			logger.Warnf("could not handle events of topic %s: %s", topicName, err.Error())
+		}
 	}
 
 	s, ok := pst.subs[topicName]
@@ -168,6 +246,7 @@ func (pst *pubsubService) subscribe(topicName string) (*pubsublibp2p.Subscriptio
 	// TODO: add sub opts, e.g. pubsublibp2p.WithBufferSize(topicCfg.BufferSize)
 	sub, err := t.Subscribe(pst.configurer.SubOpts(topicName)...)
 	if err != nil {
+		pst.cancelTopicHandler(topicName)
 		_ = t.Close()
 		delete(pst.topics, topicName)
 		return nil, err
@@ -184,21 +263,27 @@ func (pst *pubsubService) listen(sub *pubsublibp2p.Subscription, bufferSize int)
 		bufferSize = defaultPubsubMsgBufferSize
 	}
 	receiver := make(chan *pubsublibp2p.Message, bufferSize)
+	q := &subscriptionQueue{receiver: receiver}
+	// the caller holds the lock
+	pst.queues[sub.Topic()] = q
 
 	go func() {
 		topicName := sub.Topic()
 		ctx, cancel := context.WithCancel(pst.ctx)
+		var err error
 		defer func() {
-			metricPubsubListening.WithLabelValues(topicName).Dec()
+			pst.metrics.listening.WithLabelValues(topicName).Dec()
 			close(receiver)
 			sub.Cancel()
 			cancel()
+			pst.dropSubscription(sub, err)
 			logger.Debugf("stopped listening on topic %s", topicName)
 		}()
 		logger.Debugf("listening on topic %s", topicName)
-		metricPubsubListening.WithLabelValues(topicName).Inc()
+		pst.metrics.listening.WithLabelValues(topicName).Inc()
 		for ctx.Err() == nil {
-			next, err := sub.Next(ctx)
+			var next *pubsublibp2p.Message
+			next, err = sub.Next(ctx)
 			if err != nil {
 				switch err {
 				case pubsublibp2p.ErrSubscriptionCancelled, pubsublibp2p.ErrTopicClosed:
@@ -211,11 +296,14 @@ func (pst *pubsubService) listen(sub *pubsublibp2p.Subscription, bufferSize int)
 			if next == nil {
 				continue
 			}
+			pst.metrics.inBytes.WithLabelValues(topicName).Add(float64(len(next.Data)))
 			select {
 			case receiver <- next:
-				metricPubsubIn.WithLabelValues(topicName).Inc()
+				atomic.AddUint64(&q.received, 1)
+				pst.metrics.in.WithLabelValues(topicName).Inc()
 			default:
-				metricPubsubInDropped.WithLabelValues(topicName).Inc()
+				atomic.AddUint64(&q.dropped, 1)
+				pst.metrics.inDropped.WithLabelValues(topicName).Inc()
 				logger.Debugf("dropping message: queue is full [%s]:", topicName)
 			}
 		}
@@ -223,3 +311,73 @@ func (pst *pubsubService) listen(sub *pubsublibp2p.Subscription, bufferSize int)
 
 	return receiver
 }
+
+// dropSubscription removes the given subscription in case it was terminated without unsubscribing.
+// The topic is kept joined until UnSubscribe is called
+func (pst *pubsubService) dropSubscription(sub *pubsublibp2p.Subscription, err error) {
+	pst.lock.Lock()
+	defer pst.lock.Unlock()
+
+	topicName := sub.Topic()
+	if s, ok := pst.subs[topicName]; !ok || s != sub {
+		// unsubscribed
+		return
+	}
+	delete(pst.subs, topicName)
+	delete(pst.queues, topicName)
+	logger.Debugf("subscription dropped on topic %s", topicName)
+	pst.emit(events.SubscriptionDropped{Topic: topicName, Err: err})
+}
+
+// topicHandler is the event handler of a topic, and cancels the goroutine that waits for its events
+type topicHandler struct {
+	h      *pubsublibp2p.TopicEventHandler
+	cancel context.CancelFunc
+}
+
+// handleTopicEvents emits the peer events of the given topic, until the topic handler is cancelled
+func (pst *pubsubService) handleTopicEvents(topic *pubsublibp2p.Topic) error {
+	if pst.emitter == nil {
+		return nil
+	}
+	h, err := topic.EventHandler()
+	if err != nil {
+		return err
+	}
+	topicName := topic.String()
+	// cancelling the handler doesn't wake a pending NextPeerEvent, the context does
+	ctx, cancel := context.WithCancel(pst.ctx)
+	pst.topicHandlers[topicName] = &topicHandler{h: h, cancel: cancel}
+
+	go func() {
+		for {
+			pe, err := h.NextPeerEvent(ctx)
+			if err != nil {
+				return
+			}
+			switch pe.Type {
+			case pubsublibp2p.PeerJoin:
+				pst.emit(events.TopicPeerJoined{Topic: topicName, Peer: pe.Peer})
+			case pubsublibp2p.PeerLeave:
+				pst.emit(events.TopicPeerLeft{Topic: topicName, Peer: pe.Peer})
+			}
+		}
+	}()
+
+	return nil
+}
+
+// cancelTopicHandler cancels the event handler of the given topic, should be called before closing the topic
+func (pst *pubsubService) cancelTopicHandler(topicName string) {
+	if th, ok := pst.topicHandlers[topicName]; ok {
+		th.cancel()
+		th.h.Cancel()
+		delete(pst.topicHandlers, topicName)
+	}
+}
+
+func (pst *pubsubService) emit(evt events.Event) {
+	if pst.emitter != nil {
+		pst.emitter.Emit(evt)
+	}
+}
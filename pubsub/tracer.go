@@ -7,14 +7,18 @@ import (
 
 // psTracer helps to trace pubsub events
 type psTracer struct {
+	metrics *Metrics
 }
 
 // newTracer creates an instance of psTracer
-func NewReportingTracer() pubsublibp2p.EventTracer {
-	return &psTracer{}
+func NewReportingTracer(metrics *Metrics) pubsublibp2p.EventTracer {
+	if metrics == nil {
+		metrics = defaultMetrics
+	}
+	return &psTracer{metrics}
 }
 
 // Trace handles events, implementation of pubsub.EventTracer
 func (pst *psTracer) Trace(evt *ps_pb.TraceEvent) {
-	metricsPubsubTrace.WithLabelValues(evt.GetType().String()).Inc()
+	pst.metrics.trace.WithLabelValues(evt.GetType().String()).Inc()
 }
@@ -1,72 +1,65 @@
-package p2pfacade
+package p2pfacade_test
 
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	p2pfacade "github.com/amirylm/libp2p-facade"
+	"github.com/amirylm/libp2p-facade/facadetest"
 	logging "github.com/ipfs/go-log/v2"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/stretchr/testify/require"
 )
 
 func TestPubsub(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	require.NoError(t, logging.SetLogLevelRegex("p2p:.*", "debug"))
 	n := 10
-	nodes := newLocalNetwork(ctx, t, n)
-
-	<-time.After(time.Second)
+	cluster, err := facadetest.NewCluster(ctx, facadetest.ClusterConfig{
+		N:        n,
+		Topology: facadetest.RandomDegree(3, 0),
+		Options: func(i int) []p2pfacade.Option {
+			return []p2pfacade.Option{p2pfacade.WithPubsubConfigurer(facadetest.NewPubsubConfigurer())}
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, cluster.Close())
+	}()
 
 	topicName := "mytest"
-	var wg sync.WaitGroup
-	var msgCount int64
-	for _, f := range nodes {
-		wg.Add(1)
-		go func(facade Facade) {
-			err := facade.Subscribe(topicName, func(msg *pubsub.Message) {
-				require.NotNil(t, msg)
-				atomic.AddInt64(&msgCount, 1)
-			}, 2)
-			require.NoError(t, err)
-			wg.Done()
-		}(f)
+	msgCounts := make([]int64, n)
+	for i, f := range cluster.Nodes {
+		i := i
+		err := f.Subscribe(topicName, func(msg *pubsub.Message) {
+			require.NotNil(t, msg)
+			atomic.AddInt64(&msgCounts[i], 1)
+		}, n)
+		require.NoError(t, err)
 	}
-
-	wg.Wait()
 	t.Log("nodes are subscribed to topic", topicName)
 
 	// ensure that we have enough peers
-	for _, f := range nodes {
-		topic := f.GetTopic(topicName)
-		require.NotNil(t, topic)
-		peers := topic.ListPeers()
-		require.GreaterOrEqual(t, len(peers), 2)
-	}
+	require.NoError(t, cluster.WaitForTopicPeers(ctx, topicName, 2))
+	require.NoError(t, cluster.WaitForMesh(ctx, topicName, 2))
 
-	for i, f := range nodes {
-		wg.Add(1)
-		go func(facade Facade, i int) {
-			defer wg.Done()
-			<-time.After(time.Duration(rand.Intn(10)+1) * time.Millisecond * 50)
-			data := []byte(fmt.Sprintf("msg-from-node-%d", i))
-			require.NoError(t, facade.Publish(topicName, data))
-		}(f, i)
+	for i, f := range cluster.Nodes {
+		data := []byte(fmt.Sprintf("msg-from-node-%d", i))
+		require.NoError(t, f.Publish(topicName, data))
 	}
 
-	wg.Wait()
-
-	<-time.After(time.Second * 2)
-
-	require.GreaterOrEqual(t, atomic.LoadInt64(&msgCount), int64(n))
-
-	for _, f := range nodes {
-		require.NoError(t, f.Close())
-	}
+	// all nodes should get all messages
+	require.NoError(t, facadetest.WaitFor(ctx, func() bool {
+		for i := range msgCounts {
+			if atomic.LoadInt64(&msgCounts[i]) < int64(n) {
+				return false
+			}
+		}
+		return true
+	}), "%v", msgCounts)
 }
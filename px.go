@@ -0,0 +1,317 @@
+package p2pfacade
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
+	"github.com/amirylm/libp2p-facade/streams"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PeerExchangeProtocolID is the protocol of peer exchange requests
+const PeerExchangeProtocolID = protocol.ID("/facade/px/1.0.0")
+
+const (
+	// pxDefaultMaxPeers is the max number of peers in a response if not configured
+	pxDefaultMaxPeers = 16
+	// pxDefaultRateLimit is the max number of requests per minute of each peer if not configured
+	pxDefaultRateLimit = 2
+	// pxRateWindow is the window of the rate limit of each peer
+	pxRateWindow = time.Minute
+
+	pxSubsystem = "px"
+)
+
+var (
+	// ErrPeerExchangeDisabled is returned when peer exchange is used while disabled (see config.PeerExchangeConfig)
+	ErrPeerExchangeDisabled = errors.New("peer exchange is disabled")
+)
+
+// PeerExchangeFilter filters the peers of a peer exchange request
+type PeerExchangeFilter struct {
+	// Protocols are the protocols that the returned peers must support
+	Protocols []string `json:"protocols,omitempty"`
+	// Topics are the topics that the returned peers must be subscribed to
+	Topics []string `json:"topics,omitempty"`
+	// Limit is the max number of returned peers, the max of the remote peer is used if zero
+	Limit int `json:"limit,omitempty"`
+}
+
+// pxResponse is the response of a peer exchange request
+type pxResponse struct {
+	// Error is set if the request was refused
+	Error string `json:"error,omitempty"`
+	// Records are the signed peer records of the returned peers
+	Records [][]byte `json:"records,omitempty"`
+}
+
+// peerExchange serves peer exchange requests and requests peers from bootstrap peers
+type peerExchange struct {
+	facade    *facade
+	maxPeers  int
+	rateLimit int
+	filter    PeerExchangeFilter
+
+	lock     sync.Mutex
+	limiters map[peer.ID]*rateLimiter
+	lastGC   time.Time
+
+	requests *prometheus.CounterVec
+	found    prometheus.Counter
+}
+
+func newPeerExchange(f *facade, opts commons.MetricsOpts, cfg config.PeerExchangeConfig) *peerExchange {
+	px := &peerExchange{
+		facade:    f,
+		maxPeers:  cfg.MaxPeers,
+		rateLimit: cfg.RateLimit,
+		filter:    PeerExchangeFilter{Protocols: cfg.Protocols, Topics: cfg.Topics},
+		limiters:  make(map[peer.ID]*rateLimiter),
+		requests: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   pxSubsystem,
+			Name:        "requests",
+			Help:        "Counts served peer exchange requests by result (ok, limited, error)",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"result"})),
+		found: commons.RegisterCollector(opts, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   pxSubsystem,
+			Name:        "found_peers",
+			Help:        "Counts peers that were found with peer exchange",
+			ConstLabels: opts.ConstLabels,
+		})),
+	}
+	if px.maxPeers <= 0 {
+		px.maxPeers = pxDefaultMaxPeers
+	}
+	if px.rateLimit <= 0 {
+		px.rateLimit = pxDefaultRateLimit
+	}
+	return px
+}
+
+// start sets the stream handler of peer exchange, and requests peers from bootstrap peers once connected
+func (px *peerExchange) start() {
+	f := px.facade
+	f.host.SetStreamHandler(PeerExchangeProtocolID, px.handleStream)
+
+	if len(f.cfg.BootstrapPeers) == 0 {
+		return
+	}
+	// subscribing before the bootstrap peers are scheduled, so connections are not missed
+	evts, cancel := f.SubscribeEvents(len(f.cfg.BootstrapPeers), events.PeerConnected{})
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case evt, ok := <-evts:
+				if !ok {
+					return
+				}
+				if pid := evt.(events.PeerConnected).Peer; f.isBootstrapPeer(pid) {
+					go func() {
+						if _, err := f.ExchangePeers(pid, px.filter); err != nil {
+							f.logger.Debugf("could not exchange peers with bootstrap peer %s: %v", pid.String(), err)
+						}
+					}()
+				}
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// close removes the stream handler of peer exchange
+func (px *peerExchange) close() {
+	px.facade.host.RemoveStreamHandler(PeerExchangeProtocolID)
+}
+
+func (px *peerExchange) handleStream(stream libp2pnetwork.Stream) {
+	data, respond, done, err := streams.HandleStreamWithConfig(stream, px.facade.StreamConfig())
+	defer func() {
+		_ = done()
+	}()
+	if err != nil {
+		return
+	}
+	remote := stream.Conn().RemotePeer()
+	var res pxResponse
+	var filter PeerExchangeFilter
+	if !px.allow(remote, time.Now()) {
+		px.requests.WithLabelValues("limited").Inc()
+		res.Error = "rate limited"
+	} else if err := json.Unmarshal(data, &filter); err != nil {
+		px.requests.WithLabelValues("error").Inc()
+		res.Error = "invalid request"
+	} else {
+		px.requests.WithLabelValues("ok").Inc()
+		res.Records = px.sample(remote, filter)
+	}
+	resData, err := json.Marshal(res)
+	if err != nil {
+		px.facade.logger.Warnf("could not encode peer exchange response: %v", err)
+		return
+	}
+	_ = respond(resData)
+}
+
+// allow returns whether a request of the given peer is allowed by its rate limit
+func (px *peerExchange) allow(pid peer.ID, now time.Time) bool {
+	px.lock.Lock()
+	defer px.lock.Unlock()
+
+	if now.Sub(px.lastGC) >= pxRateWindow {
+		px.lastGC = now
+		for p, rl := range px.limiters {
+			if now.Sub(rl.start) >= rl.window {
+				delete(px.limiters, p)
+			}
+		}
+	}
+	rl, ok := px.limiters[pid]
+	if !ok {
+		rl = newRateLimiter(px.rateLimit, pxRateWindow)
+		px.limiters[pid] = rl
+	}
+	return rl.allow(now)
+}
+
+// sample returns the signed peer records of a random sample of connected peers that match the given filter,
+// the requesting peer is excluded
+func (px *peerExchange) sample(remote peer.ID, filter PeerExchangeFilter) [][]byte {
+	f := px.facade
+	limit := filter.Limit
+	if limit <= 0 || limit > px.maxPeers {
+		limit = px.maxPeers
+	}
+	cab, ok := peerstore.GetCertifiedAddrBook(f.host.Peerstore())
+	if !ok {
+		return nil
+	}
+	candidates := f.host.Network().Peers()
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	subscribed := px.topicPeers(filter.Topics)
+	records := make([][]byte, 0, limit)
+	for _, pid := range candidates {
+		if len(records) == limit {
+			break
+		}
+		if pid == remote || (subscribed != nil && !subscribed[pid]) || !px.supports(pid, filter.Protocols) {
+			continue
+		}
+		env := cab.GetPeerRecord(pid)
+		if env == nil {
+			continue
+		}
+		data, err := env.Marshal()
+		if err != nil {
+			continue
+		}
+		records = append(records, data)
+	}
+	return records
+}
+
+// topicPeers returns the peers that are subscribed to all the given topics, or nil if no topics were given
+func (px *peerExchange) topicPeers(topics []string) map[peer.ID]bool {
+	if len(topics) == 0 {
+		return nil
+	}
+	subscribed := make(map[peer.ID]bool)
+	ps := px.facade.ps
+	if ps == nil {
+		return subscribed
+	}
+	counts := make(map[peer.ID]int)
+	for _, topicName := range topics {
+		for _, pid := range ps.Pubsub().ListPeers(topicName) {
+			counts[pid]++
+		}
+	}
+	for pid, count := range counts {
+		if count == len(topics) {
+			subscribed[pid] = true
+		}
+	}
+	return subscribed
+}
+
+// supports returns whether the given peer supports all the given protocols
+func (px *peerExchange) supports(pid peer.ID, protocols []string) bool {
+	if len(protocols) == 0 {
+		return true
+	}
+	supported, err := px.facade.host.Peerstore().SupportsProtocols(pid, protocols...)
+	return err == nil && len(supported) == len(protocols)
+}
+
+// ExchangePeers requests peers that match the given filter from the given connected peer,
+// the verified peers (up to the limit of the filter) are added to the peerstore, scheduled for connection and returned
+func (f *facade) ExchangePeers(pid peer.ID, filter PeerExchangeFilter) ([]peer.AddrInfo, error) {
+	if f.px == nil {
+		return nil, ErrPeerExchangeDisabled
+	}
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode peer exchange request")
+	}
+	resData, err := streams.Request(pid, PeerExchangeProtocolID, data, f.StreamConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not request peers")
+	}
+	var res pxResponse
+	if err := json.Unmarshal(resData, &res); err != nil {
+		return nil, errors.Wrap(err, "could not decode peer exchange response")
+	}
+	if len(res.Error) > 0 {
+		return nil, errors.Errorf("peer exchange was refused: %s", res.Error)
+	}
+	cab, ok := peerstore.GetCertifiedAddrBook(f.host.Peerstore())
+	if !ok {
+		return nil, errors.New("peerstore does not support signed peer records")
+	}
+	found := make([]peer.AddrInfo, 0, len(res.Records))
+	for _, data := range res.Records {
+		if filter.Limit > 0 && len(found) == filter.Limit {
+			break
+		}
+		env, rec, err := record.ConsumeEnvelope(data, peer.PeerRecordEnvelopeDomain)
+		if err != nil {
+			f.logger.Debugf("invalid peer record from %s: %v", pid.String(), err)
+			continue
+		}
+		pr, ok := rec.(*peer.PeerRecord)
+		if !ok || pr.PeerID == f.host.ID() || pr.PeerID == pid || len(pr.Addrs) == 0 {
+			continue
+		}
+		// the envelope is verified with its own key, which must be the key of the peer
+		if signer, err := peer.IDFromPublicKey(env.PublicKey); err != nil || signer != pr.PeerID {
+			f.logger.Debugf("peer record of %s from %s was not signed by the peer", pr.PeerID.String(), pid.String())
+			continue
+		}
+		// stale records are not accepted, the peerstore has a newer record of the peer
+		if accepted, err := cab.ConsumePeerRecord(env, peerstore.TempAddrTTL); err != nil || !accepted {
+			continue
+		}
+		pi := peer.AddrInfo{ID: pr.PeerID, Addrs: pr.Addrs}
+		found = append(found, pi)
+		f.connector.enqueue(pi, ConnectPriorityNormal, PeerSourcePeerExchange)
+	}
+	f.px.found.Add(float64(len(found)))
+	return found, nil
+}
@@ -0,0 +1,155 @@
+package p2pfacade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/amirylm/libp2p-facade/streams"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	topicName := "test.px"
+	newNode := func(pxcfg config.PeerExchangeConfig, bootstrap ...string) Facade {
+		cfg := &config.Config{PubsubConfigurer: pubsub.NewNilConfigurer()}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.TopicDiscovery.Disabled = true
+		cfg.PeerExchange = pxcfg
+		cfg.BootstrapPeers = bootstrap
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+		return f
+	}
+
+	seed := newNode(config.PeerExchangeConfig{Enabled: true, RateLimit: 2})
+	a, c := newNode(config.PeerExchangeConfig{}), newNode(config.PeerExchangeConfig{})
+	require.NoError(t, a.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	for _, f := range []Facade{a, c} {
+		require.NoError(t, f.Host().Connect(ctx, *host.InfoFromHost(seed.Host())))
+	}
+	// the seed needs the signed peer records of a and c, and the subscription of a
+	cab, ok := peerstore.GetCertifiedAddrBook(seed.Host().Peerstore())
+	require.True(t, ok)
+	require.Eventually(t, func() bool {
+		return cab.GetPeerRecord(a.Host().ID()) != nil && cab.GetPeerRecord(c.Host().ID()) != nil &&
+			len(seed.Pubsub().ListPeers(topicName)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := a.ExchangePeers(seed.Host().ID(), PeerExchangeFilter{})
+	require.ErrorIs(t, err, ErrPeerExchangeDisabled)
+
+	// b bootstraps from the seed, and asks only for peers that are subscribed to the topic
+	seedAddr := fmt.Sprintf("%s/p2p/%s", seed.Host().Addrs()[0], seed.Host().ID())
+	b := newNode(config.PeerExchangeConfig{Enabled: true, Topics: []string{topicName}}, seedAddr)
+	require.Eventually(t, func() bool {
+		pi, ok := b.PeerInfo(a.Host().ID())
+		return ok && pi.Source == PeerSourcePeerExchange
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the second request is allowed by the rate limit of the seed, c is returned without filters
+	found, err := b.ExchangePeers(seed.Host().ID(), PeerExchangeFilter{})
+	require.NoError(t, err)
+	ids := make(map[peer.ID]bool)
+	for _, pi := range found {
+		require.NotEmpty(t, pi.Addrs)
+		ids[pi.ID] = true
+	}
+	require.Equal(t, map[peer.ID]bool{a.Host().ID(): true, c.Host().ID(): true}, ids)
+
+	_, err = b.ExchangePeers(seed.Host().ID(), PeerExchangeFilter{})
+	require.Error(t, err, "rate limited")
+}
+
+func TestPeerExchangeRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func() Facade {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.PeerExchange.Enabled = true
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+		return f
+	}
+	addr, err := ma.NewMultiaddr("/ip4/10.0.0.1/tcp/4001")
+	require.NoError(t, err)
+	signedRecord := func(pid peer.ID, sk crypto.PrivKey) []byte {
+		env, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: pid, Addrs: []ma.Multiaddr{addr}}), sk)
+		require.NoError(t, err)
+		data, err := env.Marshal()
+		require.NoError(t, err)
+		return data
+	}
+	newKey := func() (crypto.PrivKey, peer.ID) {
+		sk, _, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		pid, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+		return sk, pid
+	}
+
+	_, victim := newKey()
+	forgerKey, _ := newKey()
+	res := pxResponse{Records: [][]byte{signedRecord(victim, forgerKey)}}
+	ids := make([]peer.ID, 3)
+	for i := range ids {
+		var sk crypto.PrivKey
+		sk, ids[i] = newKey()
+		res.Records = append(res.Records, signedRecord(ids[i], sk))
+	}
+
+	// the server responds with a record of the victim that was signed by another key
+	server, f := newNode(), newNode()
+	server.Host().SetStreamHandler(PeerExchangeProtocolID, func(stream libp2pnetwork.Stream) {
+		_, respond, done, err := streams.HandleStreamWithConfig(stream, server.StreamConfig())
+		defer func() {
+			_ = done()
+		}()
+		if err != nil {
+			return
+		}
+		data, err := json.Marshal(res)
+		require.NoError(t, err)
+		_ = respond(data)
+	})
+	require.NoError(t, f.Host().Connect(ctx, *host.InfoFromHost(server.Host())))
+
+	found, err := f.ExchangePeers(server.Host().ID(), PeerExchangeFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	require.Equal(t, ids[0], found[0].ID)
+	require.Equal(t, ids[1], found[1].ID)
+	cab, ok := peerstore.GetCertifiedAddrBook(f.Host().Peerstore())
+	require.True(t, ok)
+	require.NotNil(t, cab.GetPeerRecord(ids[0]))
+	require.Nil(t, cab.GetPeerRecord(ids[2]), "records above the limit are ignored")
+	require.Nil(t, cab.GetPeerRecord(victim))
+	require.Empty(t, f.Host().Peerstore().Addrs(victim))
+	_, ok = f.PeerInfo(victim)
+	require.False(t, ok)
+}
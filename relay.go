@@ -0,0 +1,205 @@
+package p2pfacade
+
+import (
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const relaySubsystem = "relay"
+
+// relayConstraintsWindow is the window of the limits of the relay service on new reservations (see relayv2.Resources),
+// as defined by the relay service
+const relayConstraintsWindow = 30 * time.Minute
+
+// relayReservations wraps the ACL of the relay service, and tracks the reservations that were accepted.
+// The ACL is called before the relay service checks its limits on new reservations (total, per peer and per IP),
+// so the limits are checked here as well and refused reservations are not tracked.
+// The limits per ASN (IPv6 only) are not checked, reservations that are refused by them are tracked until expired.
+// Reservations are dropped once expired or once the peer was disconnected, as done by the relay service
+type relayReservations struct {
+	acl       relayv2.ACLFilter
+	resources relayv2.Resources
+
+	lock         sync.Mutex
+	reservations map[peer.ID]time.Time
+	// total, peers and ips are the expiry times of new reservations within the window of the limits
+	total []time.Time
+	peers map[peer.ID][]time.Time
+	ips   map[string][]time.Time
+
+	refused prometheus.Counter
+}
+
+var _ relayv2.ACLFilter = (*relayReservations)(nil)
+
+func newRelayReservations(opts commons.MetricsOpts, acl relayv2.ACLFilter, resources relayv2.Resources) *relayReservations {
+	rr := &relayReservations{
+		acl:          acl,
+		resources:    resources,
+		reservations: make(map[peer.ID]time.Time),
+		peers:        make(map[peer.ID][]time.Time),
+		ips:          make(map[string][]time.Time),
+		refused: commons.RegisterCollector(opts, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   relaySubsystem,
+			Name:        "reservations_refused",
+			Help:        "Counts reservations that were refused by the ACL of the relay service",
+			ConstLabels: opts.ConstLabels,
+		})),
+	}
+	commons.RegisterCollector(opts, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   relaySubsystem,
+		Name:        "reservations",
+		Help:        "Count active reservations of the relay service",
+		ConstLabels: opts.ConstLabels,
+	}, func() float64 {
+		return float64(rr.count())
+	}))
+	return rr
+}
+
+// AllowReserve implements relayv2.ACLFilter
+func (rr *relayReservations) AllowReserve(p peer.ID, a ma.Multiaddr) bool {
+	if !rr.acl.AllowReserve(p, a) {
+		rr.refused.Inc()
+		return false
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	now := time.Now()
+	// refreshed reservations are not checked by the relay service
+	if _, ok := rr.reservations[p]; !ok && !rr.addConstrained(p, a, now) {
+		return true
+	}
+	rr.reservations[p] = now.Add(rr.resources.ReservationTTL)
+	return true
+}
+
+// addConstrained adds a new reservation to the limits of the relay service,
+// it returns false if the relay service would refuse it. The caller must hold the lock
+func (rr *relayReservations) addConstrained(p peer.ID, a ma.Multiaddr, now time.Time) bool {
+	rr.total = unexpired(rr.total, now)
+	for pid, l := range rr.peers {
+		if rr.peers[pid] = unexpired(l, now); len(rr.peers[pid]) == 0 {
+			delete(rr.peers, pid)
+		}
+	}
+	for ip, l := range rr.ips {
+		if rr.ips[ip] = unexpired(l, now); len(rr.ips[ip]) == 0 {
+			delete(rr.ips, ip)
+		}
+	}
+	ip, err := manet.ToIP(a)
+	if err != nil || len(rr.total) >= rr.resources.MaxReservations ||
+		len(rr.peers[p]) >= rr.resources.MaxReservationsPerPeer || len(rr.ips[ip.String()]) >= rr.resources.MaxReservationsPerIP {
+		return false
+	}
+	expire := now.Add(relayConstraintsWindow)
+	rr.total = append(rr.total, expire)
+	rr.peers[p] = append(rr.peers[p], expire)
+	rr.ips[ip.String()] = append(rr.ips[ip.String()], expire)
+	return true
+}
+
+// unexpired returns the expiry times of the given sorted list that are after the given time
+func unexpired(l []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(l) && !l[i].After(now) {
+		i++
+	}
+	return l[i:]
+}
+
+// AllowConnect implements relayv2.ACLFilter
+func (rr *relayReservations) AllowConnect(src peer.ID, srcAddr ma.Multiaddr, dest peer.ID) bool {
+	return rr.acl.AllowConnect(src, srcAddr, dest)
+}
+
+// count returns the number of active reservations, expired reservations are removed
+func (rr *relayReservations) count() int {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	now := time.Now()
+	for p, expire := range rr.reservations {
+		if expire.Before(now) {
+			delete(rr.reservations, p)
+		}
+	}
+	return len(rr.reservations)
+}
+
+// reset removes all reservations and limits, the relay service drops them when it is stopped
+func (rr *relayReservations) reset() {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	rr.reservations = make(map[peer.ID]time.Time)
+	rr.total = nil
+	rr.peers = make(map[peer.ID][]time.Time)
+	rr.ips = make(map[string][]time.Time)
+}
+
+// notifiee returns a notify bundle that removes the reservations of disconnected peers
+func (rr *relayReservations) notifiee() *libp2pnetwork.NotifyBundle {
+	return &libp2pnetwork.NotifyBundle{
+		DisconnectedF: func(n libp2pnetwork.Network, c libp2pnetwork.Conn) {
+			pid := c.RemotePeer()
+			if n.Connectedness(pid) == libp2pnetwork.Connected {
+				return
+			}
+			rr.lock.Lock()
+			defer rr.lock.Unlock()
+
+			delete(rr.reservations, pid)
+		},
+	}
+}
+
+// setupRelayService wraps the ACL of the relay service to track reservations.
+// The returned option enables the relay service with the wrapped ACL, it must be given when creating the host
+// so it replaces the relay service option of the config
+func setupRelayService(opts commons.MetricsOpts, cfg *config.Config) (*relayReservations, libp2p.Option, error) {
+	acl := cfg.RelayACL
+	if acl == nil {
+		var err error
+		if acl, err = cfg.RelayService.ACL(); err != nil {
+			return nil, nil, err
+		}
+	}
+	resources := cfg.RelayService.Resources()
+	rr := newRelayReservations(opts, acl, resources)
+	return rr, libp2p.EnableRelayService(relayv2.WithResources(resources), relayv2.WithACL(rr)), nil
+}
+
+// registerRelayCircuits registers a gauge of the active circuits of the relay service,
+// each circuit holds a stop stream to the destination peer in the scope of the relay service
+func registerRelayCircuits(opts commons.MetricsOpts, h host.Host) {
+	commons.RegisterCollector(opts, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   relaySubsystem,
+		Name:        "circuits",
+		Help:        "Count active circuits of the relay service",
+		ConstLabels: opts.ConstLabels,
+	}, func() float64 {
+		var circuits int
+		_ = h.Network().ResourceManager().ViewService(relayv2.ServiceName, func(s libp2pnetwork.ServiceScope) error {
+			circuits = s.Stat().NumStreamsOutbound
+			return nil
+		})
+		return float64(circuits)
+	}))
+}
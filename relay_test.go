@@ -0,0 +1,117 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func(cfg *config.Config, opts ...Option) Facade {
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		f, err := New(ctx, append([]Option{WithConfig(cfg), WithMetricsRegistry(nil)}, opts...)...)
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		return f
+	}
+	allowed := newNode(&config.Config{})
+	denied := newNode(&config.Config{})
+	dialer := newNode(&config.Config{})
+	reg := prometheus.NewRegistry()
+	relayCfg := &config.Config{}
+	relayCfg.NAT.Reachability = config.ReachabilityPublic
+	relayCfg.RelayService = config.RelayServiceConfig{
+		Enabled:      true,
+		AllowedPeers: []string{allowed.Host().ID().String()},
+	}
+	relay := newNode(relayCfg, WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+	// the ACL that tracks reservations is kept by the facade, so the config can be reused
+	require.Nil(t, relayCfg.RelayACL)
+	defer func() {
+		for _, f := range []Facade{allowed, denied, dialer, relay} {
+			require.NoError(t, f.Close())
+		}
+	}()
+	relayInfo := *host.InfoFromHost(relay.Host())
+
+	// the relay service is started once the reachability event was handled
+	require.Eventually(t, func() bool {
+		_, err := client.Reserve(ctx, allowed.Host(), relayInfo)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err := client.Reserve(ctx, denied.Host(), relayInfo)
+	require.Error(t, err)
+	require.Equal(t, 1.0, gatherGauge(t, reg, "test_relay_reservations", "", ""))
+	require.Equal(t, 1.0, gatherValue(t, reg, "test_relay_reservations_refused", "", ""))
+
+	circuitAddr, err := ma.NewMultiaddr("/p2p/" + relay.Host().ID().String() + "/p2p-circuit")
+	require.NoError(t, err)
+	require.NoError(t, dialer.Host().Connect(ctx, peer.AddrInfo{
+		ID:    allowed.Host().ID(),
+		Addrs: []ma.Multiaddr{relayInfo.Addrs[0].Encapsulate(circuitAddr)},
+	}))
+	require.Eventually(t, func() bool {
+		return gatherGauge(t, reg, "test_relay_circuits", "", "") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, allowed.Host().Network().ClosePeer(relay.Host().ID()))
+	require.Eventually(t, func() bool {
+		return gatherGauge(t, reg, "test_relay_reservations", "", "") == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the gauges of a closed relay are unregistered, a new relay on the same registry reports its own reservations
+	require.NoError(t, relay.Close())
+	count, err := testutil.GatherAndCount(reg, "test_relay_reservations", "test_relay_circuits")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+	relay = newNode(relayCfg, WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+	relayInfo = *host.InfoFromHost(relay.Host())
+	require.Eventually(t, func() bool {
+		_, err := client.Reserve(ctx, allowed.Host(), relayInfo)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, 1.0, gatherGauge(t, reg, "test_relay_reservations", "", ""))
+}
+
+func TestRelayReservations(t *testing.T) {
+	acl, err := config.RelayServiceConfig{}.ACL()
+	require.NoError(t, err)
+	resources := relayv2.DefaultResources()
+	resources.MaxReservations = 2
+	resources.MaxReservationsPerIP = 1
+	rr := newRelayReservations(commons.MetricsOpts{}, acl, resources)
+
+	addr := func(ip string) ma.Multiaddr {
+		maddr, err := ma.NewMultiaddr("/ip4/" + ip + "/tcp/4001")
+		require.NoError(t, err)
+		return maddr
+	}
+	peers := make([]peer.ID, 3)
+	for i := range peers {
+		peers[i] = randPeerID(t)
+	}
+	// reservations that are allowed by the ACL but refused by the limits of the relay service are not tracked
+	require.True(t, rr.AllowReserve(peers[0], addr("10.0.0.1")))
+	require.True(t, rr.AllowReserve(peers[1], addr("10.0.0.1")))
+	require.Equal(t, 1, rr.count())
+	require.True(t, rr.AllowReserve(peers[1], addr("10.0.0.2")))
+	require.True(t, rr.AllowReserve(peers[2], addr("10.0.0.3")))
+	require.Equal(t, 2, rr.count())
+	// refreshed reservations are not limited
+	require.True(t, rr.AllowReserve(peers[0], addr("10.0.0.1")))
+	require.Equal(t, 2, rr.count())
+}
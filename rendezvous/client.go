@@ -0,0 +1,137 @@
+package rendezvous
+
+import (
+	"context"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/streams"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/pkg/errors"
+)
+
+// Client is a client of a rendezvous point, it implements discovery.Discovery
+// so it can be used to find peers for the facade (see p2pfacade.NewNamespaceDiscoverer)
+type Client struct {
+	streamCfg streams.StreamConfig
+	point     peer.ID
+}
+
+var _ discovery.Discovery = (*Client)(nil)
+
+// NewClient creates a new client of the given rendezvous point, using the host of the given stream config.
+// The addresses of the rendezvous point are added to the peerstore of the host
+func NewClient(streamCfg streams.StreamConfig, point peer.AddrInfo) *Client {
+	streamCfg.Host.Peerstore().AddAddrs(point.ID, point.Addrs, peerstore.PermanentAddrTTL)
+	return &Client{streamCfg: streamCfg, point: point.ID}
+}
+
+// Register registers our peer in the given namespace with the given TTL (the server default if zero),
+// and returns the TTL of the registration
+func (c *Client) Register(ctx context.Context, ns string, ttl time.Duration) (time.Duration, error) {
+	h := c.streamCfg.Host
+	addrs := h.Addrs()
+	req := request{Type: messageRegister, Namespace: ns, TTL: ttl, Addrs: make([]string, len(addrs))}
+	for i, addr := range addrs {
+		req.Addrs[i] = addr.String()
+	}
+	res, err := c.request(ctx, req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not register namespace %s", ns)
+	}
+	return res.TTL, nil
+}
+
+// Unregister removes the registration of our peer in the given namespace
+func (c *Client) Unregister(ctx context.Context, ns string) error {
+	_, err := c.request(ctx, request{Type: messageUnregister, Namespace: ns})
+	return errors.Wrapf(err, "could not unregister namespace %s", ns)
+}
+
+// Discover returns up to limit (all if zero) peers that were registered in the given namespace after the cookie,
+// and a cookie to discover the next peers
+func (c *Client) Discover(ctx context.Context, ns string, limit int, cookie []byte) ([]peer.AddrInfo, []byte, error) {
+	res, err := c.request(ctx, request{Type: messageDiscover, Namespace: ns, Limit: limit, Cookie: cookie})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not discover namespace %s", ns)
+	}
+	peers := make([]peer.AddrInfo, 0, len(res.Registrations))
+	for _, reg := range res.Registrations {
+		pi, err := reg.addrInfo()
+		if err != nil {
+			logger.Debugf("invalid registration in namespace %s: %v", ns, err)
+			continue
+		}
+		peers = append(peers, pi)
+	}
+	return peers, res.Cookie, nil
+}
+
+// Advertise implements discovery.Advertiser
+func (c *Client) Advertise(ctx context.Context, ns string, opts ...discovery.Option) (time.Duration, error) {
+	var options discovery.Options
+	if err := options.Apply(opts...); err != nil {
+		return 0, err
+	}
+	return c.Register(ctx, ns, options.Ttl)
+}
+
+// FindPeers implements discovery.Discoverer, all the registrations are returned page by page up to the limit
+func (c *Client) FindPeers(ctx context.Context, ns string, opts ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	var options discovery.Options
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
+	peers, cookie, err := c.Discover(ctx, ns, options.Limit, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan peer.AddrInfo, len(peers))
+	for _, pi := range peers {
+		out <- pi
+	}
+	if options.Limit > 0 || len(peers) == 0 {
+		close(out)
+		return out, nil
+	}
+	go func() {
+		defer close(out)
+		for len(peers) > 0 {
+			if peers, cookie, err = c.Discover(ctx, ns, 0, cookie); err != nil {
+				logger.Debugf("could not discover next page of namespace %s: %v", ns, err)
+				return
+			}
+			for _, pi := range peers {
+				select {
+				case out <- pi:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// request sends the given request to the rendezvous point and returns the response
+func (c *Client) request(ctx context.Context, req request) (*response, error) {
+	data, err := encode(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode request")
+	}
+	cfg := c.streamCfg
+	cfg.Ctx = ctx
+	resData, err := streams.Request(c.point, ProtocolID, data, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var res response
+	if err := decode(resData, &res); err != nil {
+		return nil, errors.Wrap(err, "could not decode response")
+	}
+	if len(res.Error) > 0 {
+		return nil, errors.New(res.Error)
+	}
+	return &res, nil
+}
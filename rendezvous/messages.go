@@ -0,0 +1,114 @@
+package rendezvous
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+// ProtocolID is the protocol of rendezvous requests
+const ProtocolID = protocol.ID("/facade/rendezvous/1.0.0")
+
+const (
+	// MaxNamespaceLength is the max length of a namespace
+	MaxNamespaceLength = 255
+	// MaxDiscoverLimit is the max number of registrations returned in a single discover request
+	MaxDiscoverLimit = 1000
+)
+
+// messageType is the type of a rendezvous request
+type messageType string
+
+const (
+	messageRegister   messageType = "register"
+	messageUnregister messageType = "unregister"
+	messageDiscover   messageType = "discover"
+)
+
+// request is a rendezvous request, the registered peer is always the peer that sent the request
+type request struct {
+	Type      messageType `json:"type"`
+	Namespace string      `json:"ns"`
+	// Addrs are the addresses of the registered peer, used by register
+	Addrs []string `json:"addrs,omitempty"`
+	// TTL is the requested TTL of the registration, the server default is used if zero
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Limit is the max number of registrations to return, used by discover
+	Limit int `json:"limit,omitempty"`
+	// Cookie is returned by a previous discover, only newer registrations are returned
+	Cookie []byte `json:"cookie,omitempty"`
+}
+
+// response is the response to a rendezvous request
+type response struct {
+	// Error is set if the request failed
+	Error string `json:"error,omitempty"`
+	// TTL is the TTL of the registration, returned by register
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Registrations are returned by discover
+	Registrations []registration `json:"registrations,omitempty"`
+	// Cookie is used to continue discovering from the last returned registration
+	Cookie []byte `json:"cookie,omitempty"`
+}
+
+// registration is a registered peer as returned by discover
+type registration struct {
+	Peer  string        `json:"peer"`
+	Addrs []string      `json:"addrs"`
+	TTL   time.Duration `json:"ttl"`
+}
+
+func (r registration) addrInfo() (peer.AddrInfo, error) {
+	pid, err := peer.Decode(r.Peer)
+	if err != nil {
+		return peer.AddrInfo{}, errors.Wrap(err, "invalid peer")
+	}
+	addrs, err := parseAddrs(r.Addrs)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	return peer.AddrInfo{ID: pid, Addrs: addrs}, nil
+}
+
+func parseAddrs(addrs []string) ([]ma.Multiaddr, error) {
+	maddrs := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid address %s", addr)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return maddrs, nil
+}
+
+func encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// encodeCookie encodes the sequence number of the last returned registration
+func encodeCookie(seq uint64) []byte {
+	cookie := make([]byte, 8)
+	binary.BigEndian.PutUint64(cookie, seq)
+	return cookie
+}
+
+// decodeCookie returns the sequence number in the cookie, or zero if empty
+func decodeCookie(cookie []byte) (uint64, error) {
+	if len(cookie) == 0 {
+		return 0, nil
+	}
+	if len(cookie) != 8 {
+		return 0, errors.New("invalid cookie")
+	}
+	return binary.BigEndian.Uint64(cookie), nil
+}
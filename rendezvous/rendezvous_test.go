@@ -0,0 +1,186 @@
+package rendezvous
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/streams"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRendezvous(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newHost := func() host.Host {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = h.Close()
+		})
+		return h
+	}
+	streamCfg := func(h host.Host) streams.StreamConfig {
+		return streams.StreamConfig{Ctx: ctx, Host: h, Timeout: 5 * time.Second}
+	}
+
+	sh := newHost()
+	srv := NewServer(streamCfg(sh), config.RendezvousServerConfig{MaxTTL: time.Hour, MaxRegistrations: 3}, commons.MetricsOpts{})
+	srv.Start()
+	defer func() {
+		require.NoError(t, srv.Close())
+	}()
+
+	clients := make([]*Client, 4)
+	for i := range clients {
+		clients[i] = NewClient(streamCfg(newHost()), *host.InfoFromHost(sh))
+	}
+	id := func(i int) peer.ID {
+		return clients[i].streamCfg.Host.ID()
+	}
+
+	ns := "test.rendezvous"
+	for _, c := range clients[:3] {
+		ttl, err := c.Register(ctx, ns, 0)
+		require.NoError(t, err)
+		// the default TTL is capped by the max TTL
+		require.Equal(t, time.Hour, ttl)
+	}
+	require.Equal(t, 3, srv.count())
+
+	t.Run("limits", func(t *testing.T) {
+		_, err := clients[3].Register(ctx, ns, 0)
+		require.Error(t, err, "namespace is full")
+		_, err = clients[3].Register(ctx, "", 0)
+		require.Error(t, err, "empty namespace")
+		_, err = clients[3].Register(ctx, "test.rendezvous.ttl", 2*time.Hour)
+		require.Error(t, err, "ttl is too long")
+		_, _, err = clients[3].Discover(ctx, ns, 0, []byte("cookie"))
+		require.Error(t, err, "invalid cookie")
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		peers, cookie, err := clients[3].Discover(ctx, ns, 2, nil)
+		require.NoError(t, err)
+		require.Len(t, peers, 2)
+		require.Equal(t, id(0), peers[0].ID)
+		require.Equal(t, id(1), peers[1].ID)
+		require.Equal(t, clients[0].streamCfg.Host.Addrs(), peers[0].Addrs)
+
+		peers, cookie, err = clients[3].Discover(ctx, ns, 2, cookie)
+		require.NoError(t, err)
+		require.Len(t, peers, 1)
+		require.Equal(t, id(2), peers[0].ID)
+
+		peers, cookie, err = clients[3].Discover(ctx, ns, 2, cookie)
+		require.NoError(t, err)
+		require.Len(t, peers, 0)
+
+		// refreshed registrations are returned again
+		_, err = clients[0].Register(ctx, ns, 0)
+		require.NoError(t, err)
+		peers, _, err = clients[3].Discover(ctx, ns, 2, cookie)
+		require.NoError(t, err)
+		require.Len(t, peers, 1)
+		require.Equal(t, id(0), peers[0].ID)
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		ttl, err := clients[3].Advertise(ctx, "test.rendezvous.disc", discovery.TTL(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, ttl)
+		peers, err := clients[0].FindPeers(ctx, "test.rendezvous.disc")
+		require.NoError(t, err)
+		var found []peer.ID
+		for pi := range peers {
+			found = append(found, pi.ID)
+		}
+		require.Equal(t, []peer.ID{id(3)}, found)
+	})
+
+	t.Run("unregister", func(t *testing.T) {
+		require.NoError(t, clients[1].Unregister(ctx, ns))
+		peers, _, err := clients[3].Discover(ctx, ns, 0, nil)
+		require.NoError(t, err)
+		require.Len(t, peers, 2)
+		for _, pi := range peers {
+			require.NotEqual(t, id(1), pi.ID)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		_, err := clients[2].Register(ctx, ns, 100*time.Millisecond)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			peers, _, err := clients[3].Discover(ctx, ns, 0, nil)
+			return err == nil && len(peers) == 1 && peers[0].ID == id(0)
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestServerRegistrations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer func() {
+		_ = h.Close()
+	}()
+	reg := prometheus.NewRegistry()
+	srv := NewServer(streams.StreamConfig{Ctx: ctx, Host: h}, config.RendezvousServerConfig{MaxPeerRegistrations: 2},
+		commons.MetricsOpts{Registerer: reg, Namespace: "test"})
+	srv.expiryInterval = 10 * time.Millisecond
+	srv.Start()
+	defer func() {
+		require.NoError(t, srv.Close())
+		// the metrics of the server are unregistered once closed
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+		require.Empty(t, mfs)
+	}()
+	addrs := []string{h.Addrs()[0].String()}
+	pid := h.ID()
+
+	t.Run("peer limits", func(t *testing.T) {
+		_, err := srv.register(pid, "ns.1", addrs, time.Minute)
+		require.NoError(t, err)
+		_, err = srv.register(pid, "ns.2", addrs, time.Minute)
+		require.NoError(t, err)
+		_, err = srv.register(pid, "ns.3", addrs, time.Minute)
+		require.Error(t, err, "peer is registered in too many namespaces")
+		// refreshed registrations are not limited
+		_, err = srv.register(pid, "ns.1", addrs, time.Minute)
+		require.NoError(t, err)
+
+		srv.unregister(pid, "ns.1")
+		_, err = srv.register(pid, "ns.3", addrs, time.Minute)
+		require.NoError(t, err)
+		srv.unregister(pid, "ns.2")
+		srv.unregister(pid, "ns.3")
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		_, err := srv.register(pid, "ns.1", addrs, 50*time.Millisecond)
+		require.NoError(t, err)
+		_, err = srv.register(pid, "ns.2", addrs, 50*time.Millisecond)
+		require.NoError(t, err)
+		// expired registrations are removed without touching the namespaces
+		require.Eventually(t, func() bool {
+			srv.lock.Lock()
+			defer srv.lock.Unlock()
+
+			return len(srv.namespaces) == 0 && len(srv.peers) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+		_, err = srv.register(pid, "ns.3", addrs, time.Minute)
+		require.NoError(t, err)
+	})
+}
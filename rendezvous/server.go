@@ -0,0 +1,352 @@
+package rendezvous
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/streams"
+	logging "github.com/ipfs/go-log/v2"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultTTL is the TTL of registrations that did not ask for a TTL, if not configured
+	DefaultTTL = 2 * time.Hour
+	// DefaultMaxTTL is the max TTL of registrations, if not configured
+	DefaultMaxTTL = 72 * time.Hour
+	// DefaultMaxRegistrations is the max number of registrations in each namespace, if not configured
+	DefaultMaxRegistrations = 1000
+	// DefaultMaxPeerRegistrations is the max number of namespaces each peer is registered in, if not configured
+	DefaultMaxPeerRegistrations = 100
+
+	// expiryInterval is the interval of removing expired registrations from all namespaces
+	expiryInterval = time.Minute
+
+	metricsSubsystem = "rendezvous"
+)
+
+var (
+	logger = logging.Logger("p2p:rendezvous")
+)
+
+// Server is a rendezvous point, it keeps the registrations of peers in namespaces until they expire
+type Server struct {
+	streamCfg            streams.StreamConfig
+	defaultTTL           time.Duration
+	maxTTL               time.Duration
+	maxRegistrations     int
+	maxPeerRegistrations int
+	expiryInterval       time.Duration
+
+	lock       sync.Mutex
+	seq        uint64
+	namespaces map[string]map[peer.ID]*serverRegistration
+	// peers is the number of namespaces each peer is registered in
+	peers map[peer.ID]int
+	// cancel stops removing expired registrations, it is set once started
+	cancel context.CancelFunc
+
+	// registerer keeps the registered collectors, they are unregistered once closed
+	registerer *commons.Registerer
+	requests   *prometheus.CounterVec
+}
+
+// serverRegistration is a registration kept by the server
+type serverRegistration struct {
+	seq    uint64
+	addrs  []string
+	expire time.Time
+}
+
+// NewServer creates a new rendezvous server on the host of the given stream config
+func NewServer(streamCfg streams.StreamConfig, cfg config.RendezvousServerConfig, opts commons.MetricsOpts) *Server {
+	var registerer *commons.Registerer
+	if opts.Registerer != nil {
+		registerer = commons.NewRegisterer(opts.Registerer)
+		opts.Registerer = registerer
+	}
+	s := &Server{
+		streamCfg:            streamCfg,
+		defaultTTL:           cfg.DefaultTTL,
+		maxTTL:               cfg.MaxTTL,
+		maxRegistrations:     cfg.MaxRegistrations,
+		maxPeerRegistrations: cfg.MaxPeerRegistrations,
+		expiryInterval:       expiryInterval,
+		namespaces:           make(map[string]map[peer.ID]*serverRegistration),
+		peers:                make(map[peer.ID]int),
+		registerer:           registerer,
+		requests: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "requests",
+			Help:        "Counts rendezvous requests by type (register, unregister, discover) and result (ok, error)",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"type", "result"})),
+	}
+	if s.defaultTTL <= 0 {
+		s.defaultTTL = DefaultTTL
+	}
+	if s.maxTTL <= 0 {
+		s.maxTTL = DefaultMaxTTL
+	}
+	if s.defaultTTL > s.maxTTL {
+		s.defaultTTL = s.maxTTL
+	}
+	if s.maxRegistrations <= 0 {
+		s.maxRegistrations = DefaultMaxRegistrations
+	}
+	if s.maxPeerRegistrations <= 0 {
+		s.maxPeerRegistrations = DefaultMaxPeerRegistrations
+	}
+	commons.RegisterCollector(opts, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   metricsSubsystem,
+		Name:        "registrations",
+		Help:        "Count active registrations in the rendezvous server",
+		ConstLabels: opts.ConstLabels,
+	}, func() float64 {
+		return float64(s.count())
+	}))
+	return s
+}
+
+// Start sets the stream handler of the rendezvous protocol, and removes expired registrations periodically
+func (s *Server) Start() {
+	ctx, cancel := context.WithCancel(s.streamCfg.Ctx)
+	s.lock.Lock()
+	s.cancel = cancel
+	s.lock.Unlock()
+	go s.expire(ctx)
+	s.streamCfg.Host.SetStreamHandler(ProtocolID, s.handleStream)
+}
+
+// Close removes the stream handler of the rendezvous protocol, stops removing expired registrations
+// and unregisters the metrics of the server
+func (s *Server) Close() error {
+	s.streamCfg.Host.RemoveStreamHandler(ProtocolID)
+	if s.registerer != nil {
+		s.registerer.UnregisterAll()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// expire removes expired registrations from all namespaces every interval until the context is done
+func (s *Server) expire(ctx context.Context) {
+	ticker := time.NewTicker(s.expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// counting removes the expired registrations
+			_ = s.count()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) handleStream(stream libp2pnetwork.Stream) {
+	data, respond, done, err := streams.HandleStreamWithConfig(stream, s.streamCfg)
+	defer func() {
+		_ = done()
+	}()
+	if err != nil {
+		return
+	}
+	remote := stream.Conn().RemotePeer()
+	var req request
+	var res *response
+	if err := decode(data, &req); err != nil {
+		res = &response{Error: "invalid request"}
+	} else {
+		res, err = s.handle(remote, req)
+		result := "ok"
+		if err != nil {
+			result = "error"
+			res = &response{Error: err.Error()}
+			logger.Debugf("could not handle %s request of peer %s: %v", req.Type, remote.String(), err)
+		}
+		s.requests.WithLabelValues(string(req.Type), result).Inc()
+	}
+	resData, err := encode(res)
+	if err != nil {
+		logger.Warnf("could not encode response: %v", err)
+		return
+	}
+	_ = respond(resData)
+}
+
+// handle handles a request of the given peer
+func (s *Server) handle(remote peer.ID, req request) (*response, error) {
+	if len(req.Namespace) == 0 || len(req.Namespace) > MaxNamespaceLength {
+		return nil, errors.New("invalid namespace")
+	}
+	switch req.Type {
+	case messageRegister:
+		ttl, err := s.register(remote, req.Namespace, req.Addrs, req.TTL)
+		if err != nil {
+			return nil, err
+		}
+		return &response{TTL: ttl}, nil
+	case messageUnregister:
+		s.unregister(remote, req.Namespace)
+		return &response{}, nil
+	case messageDiscover:
+		regs, cookie, err := s.discover(req.Namespace, req.Limit, req.Cookie)
+		if err != nil {
+			return nil, err
+		}
+		return &response{Registrations: regs, Cookie: cookie}, nil
+	default:
+		return nil, errors.Errorf("unknown request type %s", req.Type)
+	}
+}
+
+// register adds or refreshes the registration of the given peer, and returns the TTL of the registration
+func (s *Server) register(pid peer.ID, ns string, addrs []string, ttl time.Duration) (time.Duration, error) {
+	if len(addrs) == 0 {
+		return 0, errors.New("no addresses")
+	}
+	if _, err := parseAddrs(addrs); err != nil {
+		return 0, err
+	}
+	if ttl == 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl < 0 || ttl > s.maxTTL {
+		return 0, errors.Errorf("invalid ttl %s", ttl)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removeExpired(ns, time.Now())
+	regs, ok := s.namespaces[ns]
+	if !ok {
+		regs = make(map[peer.ID]*serverRegistration)
+		s.namespaces[ns] = regs
+	}
+	if _, ok := regs[pid]; !ok {
+		if len(regs) >= s.maxRegistrations {
+			return 0, errors.New("too many registrations")
+		}
+		if s.peers[pid] >= s.maxPeerRegistrations {
+			return 0, errors.New("too many registrations of peer")
+		}
+		s.peers[pid]++
+	}
+	s.seq++
+	regs[pid] = &serverRegistration{seq: s.seq, addrs: addrs, expire: time.Now().Add(ttl)}
+	return ttl, nil
+}
+
+// unregister removes the registration of the given peer
+func (s *Server) unregister(pid peer.ID, ns string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if regs, ok := s.namespaces[ns]; ok {
+		if _, ok := regs[pid]; ok {
+			s.remove(regs, pid)
+		}
+		if len(regs) == 0 {
+			delete(s.namespaces, ns)
+		}
+	}
+}
+
+// remove removes the registration of the given peer from the given registrations, the lock must be held
+func (s *Server) remove(regs map[peer.ID]*serverRegistration, pid peer.ID) {
+	delete(regs, pid)
+	if s.peers[pid]--; s.peers[pid] <= 0 {
+		delete(s.peers, pid)
+	}
+}
+
+// discover returns up to limit registrations of the given namespace that were registered after the cookie,
+// and a cookie to continue from the last returned registration
+func (s *Server) discover(ns string, limit int, cookie []byte) ([]registration, []byte, error) {
+	last, err := decodeCookie(cookie)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit <= 0 || limit > MaxDiscoverLimit {
+		limit = MaxDiscoverLimit
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	s.removeExpired(ns, now)
+	type seqRegistration struct {
+		seq uint64
+		registration
+	}
+	found := make([]seqRegistration, 0)
+	for pid, reg := range s.namespaces[ns] {
+		if reg.seq <= last {
+			continue
+		}
+		found = append(found, seqRegistration{reg.seq, registration{
+			Peer:  pid.String(),
+			Addrs: reg.addrs,
+			TTL:   reg.expire.Sub(now),
+		}})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].seq < found[j].seq
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	regs := make([]registration, len(found))
+	for i, reg := range found {
+		regs[i] = reg.registration
+		last = reg.seq
+	}
+	return regs, encodeCookie(last), nil
+}
+
+// removeExpired removes the expired registrations of the given namespace, the lock must be held
+func (s *Server) removeExpired(ns string, now time.Time) {
+	regs, ok := s.namespaces[ns]
+	if !ok {
+		return
+	}
+	for pid, reg := range regs {
+		if !reg.expire.After(now) {
+			s.remove(regs, pid)
+		}
+	}
+	if len(regs) == 0 {
+		delete(s.namespaces, ns)
+	}
+}
+
+// count returns the number of active registrations in all namespaces
+func (s *Server) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	var count int
+	for ns := range s.namespaces {
+		s.removeExpired(ns, now)
+		count += len(s.namespaces[ns])
+	}
+	return count
+}
@@ -0,0 +1,55 @@
+package p2pfacade
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRendezvous(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	newNode := func(rcfg config.RendezvousConfig, opts ...Option) Facade {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.Rendezvous = rcfg
+		f, err := New(ctx, append([]Option{WithConfig(cfg), WithMetricsRegistry(nil)}, opts...)...)
+		require.NoError(t, err)
+		require.NoError(t, f.Start(nil))
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+		return f
+	}
+
+	point := newNode(config.RendezvousConfig{Server: config.RendezvousServerConfig{Enabled: true}},
+		WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+	pointAddr := fmt.Sprintf("%s/p2p/%s", point.Host().Addrs()[0], point.Host().ID())
+	rcfg := config.RendezvousConfig{Points: []string{pointAddr}, Namespace: "test.rendezvous", Interval: 100 * time.Millisecond}
+	a, b := newNode(rcfg), newNode(rcfg)
+
+	// either a or b dials the other, the source is set on the dialing side
+	discovered := func(f Facade, pid peer.ID) bool {
+		pi, ok := f.PeerInfo(pid)
+		return ok && pi.Source == PeerSourceRendezvous
+	}
+	require.Eventually(t, func() bool {
+		return discovered(a, b.Host().ID()) || discovered(b, a.Host().ID())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// both nodes are registered in the rendezvous point
+	require.Equal(t, float64(2), gatherGauge(t, reg, "test_rendezvous_registrations", "", ""))
+
+	cfg := &config.Config{}
+	cfg.Rendezvous = config.RendezvousConfig{Points: []string{pointAddr}}
+	_, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+	require.Error(t, err, "rendezvous points without a namespace")
+}
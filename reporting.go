@@ -1,17 +1,73 @@
 package p2pfacade
 
 import (
+	"github.com/amirylm/libp2p-facade/commons"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var (
-	metricConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "p2p_peers_connected",
-		Help: "Count connected peers",
-	}, []string{"pid"})
-)
+// metrics contains the metrics of the facade
+type metrics struct {
+	connections      prometheus.Gauge
+	connector        *prometheus.CounterVec
+	connectorPending prometheus.Gauge
+	staticPeers      *prometheus.GaugeVec
+	latency          prometheus.Histogram
+	pingFailures     prometheus.Counter
+	reachability     *prometheus.GaugeVec
+	discovered       *prometheus.CounterVec
+}
 
-func init() {
-	_ = prometheus.Register(metricConnections)
+func newMetrics(opts commons.MetricsOpts) *metrics {
+	return &metrics{
+		connections: commons.RegisterCollector(opts, prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Name:        "peers_connected",
+			Help:        "Count connected peers",
+			ConstLabels: opts.ConstLabels,
+		})),
+		connector: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "connector_peers",
+			Help:        "Counts peers handled by the connector by status (queued, dropped, connected, failed)",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"status"})),
+		connectorPending: commons.RegisterCollector(opts, prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Name:        "connector_pending",
+			Help:        "Count peers that are waiting to be connected",
+			ConstLabels: opts.ConstLabels,
+		})),
+		staticPeers: commons.RegisterCollector(opts, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Name:        "static_peers",
+			Help:        "Count static peers by state (connected, disconnected)",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"state"})),
+		latency: commons.RegisterCollector(opts, prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "peer_latency_seconds",
+			Help:        "Round trip time of pings to connected peers",
+			ConstLabels: opts.ConstLabels,
+			// 1ms to ~8s
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		})),
+		pingFailures: commons.RegisterCollector(opts, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "ping_failures",
+			Help:        "Counts failed pings to connected peers",
+			ConstLabels: opts.ConstLabels,
+		})),
+		reachability: commons.RegisterCollector(opts, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Name:        "reachability",
+			Help:        "The reachability of the node (Unknown, Public, Private), the current one is set to 1",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"reachability"})),
+		discovered: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "discovered_peers",
+			Help:        "Counts found peers by source and status (accepted, duplicate, limited)",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"source", "status"})),
+	}
 }
@@ -0,0 +1,120 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/libp2p/go-libp2p-core/host"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newCfg := func() *config.Config {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.PubsubConfigurer = pubsub.NewNilConfigurer()
+		return cfg
+	}
+
+	reg := prometheus.NewRegistry()
+	nodes := make([]Facade, 0)
+	for _, name := range []string{"a", "b"} {
+		f, err := New(ctx, WithConfig(newCfg()), WithMetricsRegistry(reg),
+			WithMetricsNamespace("test"), WithMetricsLabels(prometheus.Labels{"node": name}))
+		require.NoError(t, err)
+		nodes = append(nodes, f)
+	}
+	// no-op metrics
+	noop, err := New(ctx, WithConfig(newCfg()), WithMetricsRegistry(nil))
+	require.NoError(t, err)
+	nodes = append(nodes, noop)
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+
+	require.NoError(t, nodes[0].Host().Connect(ctx, *host.InfoFromHost(nodes[1].Host())))
+	require.NoError(t, nodes[2].Host().Connect(ctx, *host.InfoFromHost(nodes[1].Host())))
+	require.NoError(t, nodes[0].Subscribe("test.metrics", func(m *pubsublibp2p.Message) {}, 0))
+
+	require.Eventually(t, func() bool {
+		connected := gatherConnectedPeers(t, reg)
+		return connected["a"] == 1 && connected["b"] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, gatherConnectedPeers(t, reg), 2)
+	count, err := testutil.GatherAndCount(reg, "test_pubsub_topics")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	count, err = testutil.GatherAndCount(reg, "p2p_peers_connected")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// closed node should be removed from the gauge
+	require.NoError(t, nodes[2].Close())
+	require.Eventually(t, func() bool {
+		return gatherConnectedPeers(t, reg)["b"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsUnregistered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	newNode := func() Facade {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.PubsubConfigurer = pubsub.NewNilConfigurer()
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(reg),
+			WithMetricsNamespace("test"), WithMetricsLabels(prometheus.Labels{"node": "a"}))
+		require.NoError(t, err)
+		return f
+	}
+	f := newNode()
+	other := newNode()
+	require.NoError(t, f.Host().Connect(ctx, *host.InfoFromHost(other.Host())))
+	// the metrics of the other node were not registered, as it uses the same namespace and labels
+	require.Eventually(t, func() bool {
+		connected := gatherConnectedPeers(t, reg)
+		return len(connected) == 1 && connected["a"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, other.Close())
+
+	// the metrics of a closed node are unregistered, so a new node can register its own metrics
+	require.NoError(t, f.Close())
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.Empty(t, mfs)
+	f = newNode()
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	require.Equal(t, map[string]float64{"a": 0}, gatherConnectedPeers(t, reg))
+}
+
+// gatherConnectedPeers returns the connected peers gauge values by node label
+func gatherConnectedPeers(t *testing.T, reg prometheus.Gatherer) map[string]float64 {
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	connected := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "test_peers_connected" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			require.Len(t, m.GetLabel(), 1)
+			connected[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	return connected
+}
@@ -0,0 +1,138 @@
+package p2pfacade
+
+import (
+	"github.com/amirylm/libp2p-facade/commons"
+	"github.com/amirylm/libp2p-facade/config"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// blocked resources labels
+const (
+	resourceConn     = "conn"
+	resourceStream   = "stream"
+	resourcePeer     = "peer"
+	resourceProtocol = "protocol"
+	resourceService  = "service"
+	resourceMemory   = "memory"
+)
+
+// resourceMetrics reports the resources that were blocked by the resource manager
+type resourceMetrics struct {
+	blocked          *prometheus.CounterVec
+	blockedProtocols *prometheus.CounterVec
+}
+
+var _ rcmgr.MetricsReporter = (*resourceMetrics)(nil)
+
+func newResourceMetrics(opts commons.MetricsOpts) *resourceMetrics {
+	return &resourceMetrics{
+		blocked: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "resources_blocked",
+			Help:        "Counts resources blocked by the resource manager by resource (conn, stream, peer, protocol, service, memory) and direction",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"resource", "direction"})),
+		blockedProtocols: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "resources_blocked_protocol",
+			Help:        "Counts streams blocked by the resource manager by protocol",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"protocol"})),
+	}
+}
+
+// directionLabel returns the label of the given direction
+func directionLabel(dir libp2pnetwork.Direction) string {
+	switch dir {
+	case libp2pnetwork.DirInbound:
+		return "in"
+	case libp2pnetwork.DirOutbound:
+		return "out"
+	default:
+		return ""
+	}
+}
+
+// AllowConn implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) AllowConn(dir libp2pnetwork.Direction, usefd bool) {}
+
+// BlockConn implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockConn(dir libp2pnetwork.Direction, usefd bool) {
+	rm.blocked.WithLabelValues(resourceConn, directionLabel(dir)).Inc()
+}
+
+// AllowStream implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) AllowStream(p peer.ID, dir libp2pnetwork.Direction) {}
+
+// BlockStream implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockStream(p peer.ID, dir libp2pnetwork.Direction) {
+	rm.blocked.WithLabelValues(resourceStream, directionLabel(dir)).Inc()
+}
+
+// AllowPeer implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) AllowPeer(p peer.ID) {}
+
+// BlockPeer implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockPeer(p peer.ID) {
+	rm.blocked.WithLabelValues(resourcePeer, "").Inc()
+}
+
+// AllowProtocol implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) AllowProtocol(proto protocol.ID) {}
+
+// BlockProtocol implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockProtocol(proto protocol.ID) {
+	rm.blocked.WithLabelValues(resourceProtocol, "").Inc()
+	rm.blockedProtocols.WithLabelValues(string(proto)).Inc()
+}
+
+// BlockProtocolPeer implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockProtocolPeer(proto protocol.ID, p peer.ID) {
+	rm.blocked.WithLabelValues(resourceProtocol, "").Inc()
+	rm.blockedProtocols.WithLabelValues(string(proto)).Inc()
+}
+
+// AllowService implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) AllowService(svc string) {}
+
+// BlockService implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockService(svc string) {
+	rm.blocked.WithLabelValues(resourceService, "").Inc()
+}
+
+// BlockServicePeer implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockServicePeer(svc string, p peer.ID) {
+	rm.blocked.WithLabelValues(resourceService, "").Inc()
+}
+
+// AllowMemory implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) AllowMemory(size int) {}
+
+// BlockMemory implements rcmgr.MetricsReporter
+func (rm *resourceMetrics) BlockMemory(size int) {
+	rm.blocked.WithLabelValues(resourceMemory, "").Inc()
+}
+
+// SetStreamHandler implements Facade
+func (f *facade) SetStreamHandler(proto protocol.ID, handler libp2pnetwork.StreamHandler, limit config.ResourceLimit) error {
+	if !limit.IsZero() {
+		err := f.host.Network().ResourceManager().ViewProtocol(proto, func(scope libp2pnetwork.ProtocolScope) error {
+			limiter, ok := scope.(rcmgr.ResourceScopeLimiter)
+			if !ok {
+				return errors.New("resource manager does not support limits")
+			}
+			limiter.SetLimit(limit.Apply(limiter.Limit()))
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "could not set resource limit of protocol %s", proto)
+		}
+	}
+	f.host.SetStreamHandler(proto, handler)
+	return nil
+}
@@ -0,0 +1,77 @@
+package p2pfacade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	nodes := make([]Facade, 2)
+	for i := range nodes {
+		cfg := &config.Config{}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		opts := []Option{WithConfig(cfg), WithMetricsRegistry(nil)}
+		if i == 0 {
+			opts = append(opts, WithMetricsRegistry(reg), WithMetricsNamespace("test"))
+		}
+		f, err := New(ctx, opts...)
+		require.NoError(t, err)
+		// the metrics of the facade are not kept in the config, which might be reused by other facades
+		require.Nil(t, cfg.ResourceMetrics)
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+
+	proto := protocol.ID("/test/rcmgr/1.0.0")
+	release := make(chan struct{})
+	defer close(release)
+	handler := func(s libp2pnetwork.Stream) {
+		defer func() {
+			_ = s.Close()
+		}()
+		buf := make([]byte, 1)
+		_, _ = s.Read(buf)
+		_, _ = s.Write(buf)
+		<-release
+	}
+	require.NoError(t, nodes[0].SetStreamHandler(proto, handler, config.ResourceLimit{StreamsInbound: 1}))
+	require.NoError(t, nodes[1].Host().Connect(ctx, *host.InfoFromHost(nodes[0].Host())))
+
+	request := func() error {
+		s, err := nodes[1].Host().NewStream(ctx, nodes[0].Host().ID(), proto)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Write([]byte{1}); err != nil {
+			return err
+		}
+		buf := make([]byte, 1)
+		_ = s.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = s.Read(buf)
+		return err
+	}
+	// the first stream is kept open by the handler, so the second one is blocked
+	require.NoError(t, request())
+	require.Error(t, request())
+	require.Eventually(t, func() bool {
+		return gatherValue(t, reg, "test_resources_blocked_protocol", "protocol", string(proto)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(1), gatherValue(t, reg, "test_resources_blocked", "resource", resourceProtocol))
+}
@@ -0,0 +1,106 @@
+package p2pfacade
+
+import (
+	"sync"
+
+	"github.com/amirylm/libp2p-facade/events"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+const (
+	// staticPeerTag is the tag used to protect static peers in the connection manager
+	staticPeerTag = "facade:static"
+)
+
+// static peers state labels
+const (
+	staticPeerConnected    = "connected"
+	staticPeerDisconnected = "disconnected"
+)
+
+// staticPeers keeps static peers connected, the peers are reconnected with backoff whenever they disconnect
+type staticPeers struct {
+	connector *connector
+	metrics   *metrics
+	events    events.Emitter
+	logger    logging.StandardLogger
+
+	lock      sync.Mutex
+	peers     map[peer.ID]peer.AddrInfo
+	connected map[peer.ID]bool
+}
+
+func newStaticPeers(c *connector, metrics *metrics, emitter events.Emitter, logger logging.StandardLogger,
+	peers []peer.AddrInfo) *staticPeers {
+	sp := &staticPeers{
+		connector: c,
+		metrics:   metrics,
+		events:    emitter,
+		logger:    logger,
+		peers:     make(map[peer.ID]peer.AddrInfo),
+		connected: make(map[peer.ID]bool),
+	}
+	for _, pi := range peers {
+		sp.peers[pi.ID] = pi
+	}
+	metrics.staticPeers.WithLabelValues(staticPeerDisconnected).Set(float64(len(sp.peers)))
+	return sp
+}
+
+// start protects the static peers and schedules connections to them
+func (sp *staticPeers) start(h host.Host) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	for pid, pi := range sp.peers {
+		h.ConnManager().Protect(pid, staticPeerTag)
+		h.Peerstore().AddAddrs(pid, pi.Addrs, peerstore.PermanentAddrTTL)
+		if h.Network().Connectedness(pid) != libp2pnetwork.Connected {
+			sp.connector.enqueue(pi, ConnectPriorityHigh, PeerSourceStatic)
+		}
+	}
+}
+
+// notifiee returns a notify bundle that tracks the state of static peers, and reconnects them once disconnected
+func (sp *staticPeers) notifiee() *libp2pnetwork.NotifyBundle {
+	return &libp2pnetwork.NotifyBundle{
+		ConnectedF: func(n libp2pnetwork.Network, c libp2pnetwork.Conn) {
+			sp.lock.Lock()
+			defer sp.lock.Unlock()
+
+			pid := c.RemotePeer()
+			if _, ok := sp.peers[pid]; !ok || sp.connected[pid] {
+				return
+			}
+			sp.connected[pid] = true
+			sp.reportState()
+			sp.logger.Debugf("static peer %s was connected", pid.String())
+			sp.events.Emit(events.StaticPeerConnected{Peer: pid})
+		},
+		DisconnectedF: func(n libp2pnetwork.Network, c libp2pnetwork.Conn) {
+			sp.lock.Lock()
+			defer sp.lock.Unlock()
+
+			pid := c.RemotePeer()
+			pi, ok := sp.peers[pid]
+			if !ok || !sp.connected[pid] || n.Connectedness(pid) == libp2pnetwork.Connected {
+				return
+			}
+			delete(sp.connected, pid)
+			sp.reportState()
+			sp.logger.Debugf("static peer %s was disconnected, reconnecting", pid.String())
+			sp.events.Emit(events.StaticPeerDisconnected{Peer: pid})
+			sp.connector.enqueue(pi, ConnectPriorityHigh, PeerSourceStatic)
+		},
+	}
+}
+
+// reportState updates the static peers metrics, the caller must hold the lock
+func (sp *staticPeers) reportState() {
+	sp.metrics.staticPeers.WithLabelValues(staticPeerConnected).Set(float64(len(sp.connected)))
+	sp.metrics.staticPeers.WithLabelValues(staticPeerDisconnected).Set(float64(len(sp.peers) - len(sp.connected)))
+}
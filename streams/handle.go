@@ -15,11 +15,19 @@ type CloseStream func() error
 
 // HandleStream is called at the beginning of stream handlers to create a wrapper stream and read first message
 func HandleStream(stream core.Stream, timeout time.Duration) ([]byte, RespondStream, CloseStream, error) {
+	return HandleStreamWithConfig(stream, StreamConfig{Timeout: timeout})
+}
+
+// HandleStreamWithConfig is the same as HandleStream, using the timeout and metrics of the given config
+func HandleStreamWithConfig(stream core.Stream, cfg StreamConfig) ([]byte, RespondStream, CloseStream, error) {
 	protocol := stream.Protocol()
+	timeout := cfg.Timeout
+	metrics := cfg.metrics()
 
-	metricStreamIn.WithLabelValues(string(protocol)).Inc()
+	metrics.in.WithLabelValues(string(protocol)).Inc()
 	s := NewStream(stream)
-	pid := stream.Conn().RemotePeer().String()
+	remote := stream.Conn().RemotePeer()
+	pid := remote.String()
 	done := func() error {
 		logger.Debugf("closing stream %s, src peer: %s", string(protocol), pid)
 		return s.Close()
@@ -29,16 +37,20 @@ func HandleStream(stream core.Stream, timeout time.Duration) ([]byte, RespondStr
 	}
 	data, err := s.ReadWithTimeout(timeout)
 	if err != nil {
-		metricStreamInDone.WithLabelValues(string(protocol), "read").Inc()
-		return nil, nil, done, errors.Wrap(err, "could not read stream msg")
+		metrics.inDone.WithLabelValues(string(protocol), "read").Inc()
+		err = errors.Wrap(err, "could not read stream msg")
+		cfg.handlerError(protocol, remote, err)
+		return nil, nil, done, err
 	}
 	respond := func(res []byte) error {
 		if err := s.WriteWithTimeout(res, timeout); err != nil {
-			metricStreamInDone.WithLabelValues(string(protocol), "write").Inc()
-			return errors.Wrap(err, "could not write to stream")
+			metrics.inDone.WithLabelValues(string(protocol), "write").Inc()
+			err = errors.Wrap(err, "could not write to stream")
+			cfg.handlerError(protocol, remote, err)
+			return err
 		}
 		logger.Debugf("handle stream success %s, src peer: %s", string(protocol), pid)
-		metricStreamInDone.WithLabelValues(string(protocol), "").Inc()
+		metrics.inDone.WithLabelValues(string(protocol), "").Inc()
 		return nil
 	}
 
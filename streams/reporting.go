@@ -1,32 +1,53 @@
 package streams
 
 import (
+	"github.com/amirylm/libp2p-facade/commons"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var (
-	metricStreamOut = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_streams_out",
-		Help: "Counts outgoing streams requests",
-	}, []string{"protocol"})
-	metricStreamOutDone = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_streams_out_done",
-		Help: "Counts failed outgoing streams requests",
-	}, []string{"protocol", "err"})
-	metricStreamIn = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_streams_in",
-		Help: "Counts incoming streams requests",
-	}, []string{"protocol"})
-	metricStreamInDone = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "p2p_streams_in_done",
-		Help: "Counts failed outgoing streams requests",
-	}, []string{"protocol", "err"})
-)
+const metricsSubsystem = "streams"
+
+// Metrics contains the metrics of streams
+type Metrics struct {
+	out     *prometheus.CounterVec
+	outDone *prometheus.CounterVec
+	in      *prometheus.CounterVec
+	inDone  *prometheus.CounterVec
+}
 
-func init() {
-	_ = prometheus.Register(metricStreamOut)
-	_ = prometheus.Register(metricStreamOutDone)
-	_ = prometheus.Register(metricStreamIn)
-	_ = prometheus.Register(metricStreamInDone)
+// NewMetrics creates streams metrics with the given options
+func NewMetrics(opts commons.MetricsOpts) *Metrics {
+	return &Metrics{
+		out: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "out",
+			Help:        "Counts outgoing streams requests",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"protocol"})),
+		outDone: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "out_done",
+			Help:        "Counts failed outgoing streams requests",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"protocol", "err"})),
+		in: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "in",
+			Help:        "Counts incoming streams requests",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"protocol"})),
+		inDone: commons.RegisterCollector(opts, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "in_done",
+			Help:        "Counts failed outgoing streams requests",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"protocol", "err"})),
+	}
 }
+
+// defaultMetrics are used when no metrics were provided, they are not registered (no-op)
+var defaultMetrics = NewMetrics(commons.MetricsOpts{})
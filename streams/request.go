@@ -2,6 +2,8 @@ package streams
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -17,6 +19,8 @@ const (
 
 var (
 	logger = logging.Logger("p2p:stream")
+	// requestCounter is used to create a unique protection tag for every request
+	requestCounter uint64
 )
 
 // StreamConfig is the config object required to make a request
@@ -24,6 +28,23 @@ type StreamConfig struct {
 	Ctx     context.Context
 	Host    host.Host
 	Timeout time.Duration
+	// Metrics are used to report streams metrics, no-op metrics are used if nil
+	Metrics *Metrics
+	// OnHandlerError is called when handling an incoming stream fails, optional
+	OnHandlerError func(protocol protocol.ID, peerID peer.ID, err error)
+}
+
+func (cfg StreamConfig) handlerError(protocol protocol.ID, peerID peer.ID, err error) {
+	if cfg.OnHandlerError != nil {
+		cfg.OnHandlerError(protocol, peerID, err)
+	}
+}
+
+func (cfg StreamConfig) metrics() *Metrics {
+	if cfg.Metrics == nil {
+		return defaultMetrics
+	}
+	return cfg.Metrics
 }
 
 // Request sends a message to the given stream and returns the response
@@ -32,7 +53,12 @@ func Request(peerID peer.ID, protocol protocol.ID, data []byte, cfg StreamConfig
 	if err != nil {
 		return nil, err
 	}
-	metricStreamOut.WithLabelValues(string(protocol)).Inc()
+	// protecting the connection while the request is in-flight, so it won't be trimmed by the connection manager
+	tag := fmt.Sprintf("stream:request:%d", atomic.AddUint64(&requestCounter, 1))
+	cfg.Host.ConnManager().Protect(peerID, tag)
+	defer cfg.Host.ConnManager().Unprotect(peerID, tag)
+	metrics := cfg.metrics()
+	metrics.out.WithLabelValues(string(protocol)).Inc()
 	stream := NewStream(s)
 	defer func() {
 		_ = stream.Close()
@@ -42,19 +68,19 @@ func Request(peerID peer.ID, protocol protocol.ID, data []byte, cfg StreamConfig
 		timeout = DefaultTimeout
 	}
 	if err := stream.WriteWithTimeout(data, timeout); err != nil {
-		metricStreamOutDone.WithLabelValues(string(protocol), "write").Inc()
+		metrics.outDone.WithLabelValues(string(protocol), "write").Inc()
 		return nil, errors.Wrap(err, "could not write to stream")
 	}
 	if err := s.CloseWrite(); err != nil {
-		metricStreamOutDone.WithLabelValues(string(protocol), "close_write").Inc()
+		metrics.outDone.WithLabelValues(string(protocol), "close_write").Inc()
 		return nil, errors.Wrap(err, "could not close-write stream")
 	}
 	res, err := stream.ReadWithTimeout(timeout)
 	if err != nil {
-		metricStreamOutDone.WithLabelValues(string(protocol), "read").Inc()
+		metrics.outDone.WithLabelValues(string(protocol), "read").Inc()
 		return nil, errors.Wrap(err, "could not read stream msg")
 	}
 	logger.Debugf("successful stream request %s, target peer: %s", string(protocol), peerID.String())
-	metricStreamOutDone.WithLabelValues(string(protocol), "").Inc()
+	metrics.outDone.WithLabelValues(string(protocol), "").Inc()
 	return res, nil
 }
@@ -53,17 +53,15 @@ func (ts *streamWrapper) CloseWrite() error {
 
 // ReadWithTimeout reads with timeout
 func (ts *streamWrapper) ReadWithTimeout(timeout time.Duration) ([]byte, error) {
-	if err := ts.s.SetReadDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, errors.Wrap(err, "could not set read deadline")
-	}
+	stop := ts.withDeadline(ts.s.SetReadDeadline, timeout)
+	defer stop()
 	return ioutil.ReadAll(ts.s)
 }
 
 // WriteWithTimeout reads next message with timeout
 func (ts *streamWrapper) WriteWithTimeout(data []byte, timeout time.Duration) error {
-	if err := ts.s.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
-		return errors.Wrap(err, "could not set write deadline")
-	}
+	stop := ts.withDeadline(ts.s.SetWriteDeadline, timeout)
+	defer stop()
 
 	n := len(data)
 	bytsWrote, err := ts.s.Write(data)
@@ -73,6 +71,20 @@ func (ts *streamWrapper) WriteWithTimeout(data []byte, timeout time.Duration) er
 	return err
 }
 
+// withDeadline sets a deadline with the given function, if deadlines are not supported (e.g. mocknet)
+// the stream is reset once the timeout has passed. The returned function should be called once done.
+func (ts *streamWrapper) withDeadline(setDeadline func(time.Time) error, timeout time.Duration) func() {
+	if err := setDeadline(time.Now().Add(timeout)); err == nil {
+		return func() {}
+	}
+	timer := time.AfterFunc(timeout, func() {
+		_ = ts.s.Reset()
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
 // ID returns the id of the stream
 func (ts *streamWrapper) ID() string {
 	return ts.s.ID()
@@ -0,0 +1,73 @@
+package p2pfacade_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/facadetest"
+	"github.com/amirylm/libp2p-facade/streams"
+	logging "github.com/ipfs/go-log/v2"
+	core "github.com/libp2p/go-libp2p-core"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.NoError(t, logging.SetLogLevelRegex("p2p:.*", "debug"))
+	n := 10
+	cluster, err := facadetest.NewCluster(ctx, facadetest.ClusterConfig{
+		N:        n,
+		Topology: facadetest.FullMesh(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, cluster.Close())
+	}()
+	nodes := cluster.Nodes
+
+	pid := core.ProtocolID("/mytest")
+	var successMsgCount int64
+	for _, f := range nodes {
+		cfg := f.StreamConfig()
+		cfg.Timeout = 5 * time.Second
+		f.Host().SetStreamHandler(pid, func(s core.Stream) {
+			req, res, done, err := streams.HandleStreamWithConfig(s, cfg)
+			require.NoError(t, err)
+			defer func() {
+				_ = done()
+			}()
+			require.True(t, strings.Contains(string(req), "msg-from-node"))
+			require.NoError(t, res(req))
+			atomic.AddInt64(&successMsgCount, 1)
+		})
+	}
+	t.Log("configured stream handlers", pid)
+
+	var wg sync.WaitGroup
+	send := func(from, to int) {
+		defer wg.Done()
+		cfg := nodes[from].StreamConfig()
+		cfg.Timeout = 2 * time.Second
+		res, err := streams.Request(nodes[to].Host().ID(), pid, []byte(fmt.Sprintf("msg-from-node-%d", from)), cfg)
+		require.NoError(t, err)
+		require.True(t, strings.Contains(string(res), "msg-from-node"))
+	}
+
+	wg.Add(4)
+	go send(0, 1)
+	go send(2, 5)
+	go send(0, 3)
+	go send(5, 4)
+	wg.Wait()
+
+	require.NoError(t, facadetest.WaitFor(ctx, func() bool {
+		return atomic.LoadInt64(&successMsgCount) == 4
+	}))
+}
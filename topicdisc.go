@@ -0,0 +1,160 @@
+package p2pfacade
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/events"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	libp2pdisc "github.com/libp2p/go-libp2p-discovery"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+)
+
+const (
+	// topicDiscoveryNamespacePrefix is the prefix of topic namespaces, same as used by pubsub discovery
+	topicDiscoveryNamespacePrefix = "floodsub:"
+	// topicDiscoveryDefaultInterval is the interval of checking topics if not configured
+	topicDiscoveryDefaultInterval = time.Minute
+	// topicDiscoveryDefaultLimit is the max number of peers to find in each lookup if not configured
+	topicDiscoveryDefaultLimit = 16
+	// topicDiscoveryTimeout is the timeout of a single lookup
+	topicDiscoveryTimeout = 30 * time.Second
+)
+
+// topicDiscovery advertises the topics that we joined, and finds peers for topics with not enough mesh peers.
+// Found peers are connected by the connector with the low priority of routing discovery
+type topicDiscovery struct {
+	ctx       context.Context
+	disc      discovery.Discovery
+	facade    *facade
+	connector *connector
+
+	interval     time.Duration
+	minMeshPeers int
+	limit        int
+
+	lock       sync.Mutex
+	advertised map[string]context.CancelFunc
+}
+
+func newTopicDiscovery(f *facade, cfg config.TopicDiscoveryConfig) *topicDiscovery {
+	td := &topicDiscovery{
+		ctx:          f.ctx,
+		disc:         f.disc,
+		facade:       f,
+		connector:    f.connector,
+		interval:     cfg.Interval,
+		minMeshPeers: cfg.MinMeshPeers,
+		limit:        cfg.Limit,
+		advertised:   make(map[string]context.CancelFunc),
+	}
+	if td.interval <= 0 {
+		td.interval = topicDiscoveryDefaultInterval
+	}
+	if td.minMeshPeers <= 0 {
+		td.minMeshPeers = pubsublibp2p.GossipSubDlo
+	}
+	if td.limit <= 0 {
+		td.limit = topicDiscoveryDefaultLimit
+	}
+	return td
+}
+
+// run handles joined and left topics, and checks all topics every interval until the context is done
+func (td *topicDiscovery) run() {
+	evts, cancel := td.facade.SubscribeEvents(0, events.TopicJoined{}, events.TopicLeft{})
+	defer cancel()
+	ticker := time.NewTicker(td.interval)
+	defer ticker.Stop()
+
+	td.sync()
+	for {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				return
+			}
+			switch e := evt.(type) {
+			case events.TopicJoined:
+				td.advertise(e.Topic)
+				go td.findPeers(e.Topic)
+			case events.TopicLeft:
+				td.stopAdvertise(e.Topic)
+			}
+		case <-ticker.C:
+			td.sync()
+		case <-td.ctx.Done():
+			return
+		}
+	}
+}
+
+// sync advertises the current topics, stops advertising topics that we left,
+// and finds peers for topics with not enough mesh peers
+func (td *topicDiscovery) sync() {
+	topics := td.facade.Topics()
+	current := make(map[string]bool, len(topics))
+	for _, topicName := range topics {
+		current[topicName] = true
+		td.advertise(topicName)
+		if len(td.facade.MeshPeers(topicName)) < td.minMeshPeers {
+			go td.findPeers(topicName)
+		}
+	}
+
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	for topicName, cancel := range td.advertised {
+		if !current[topicName] {
+			cancel()
+			delete(td.advertised, topicName)
+		}
+	}
+}
+
+// advertise starts advertising the given topic, until the topic was left
+func (td *topicDiscovery) advertise(topicName string) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	if _, ok := td.advertised[topicName]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(td.ctx)
+	td.advertised[topicName] = cancel
+	libp2pdisc.Advertise(ctx, td.disc, topicDiscoveryNamespacePrefix+topicName)
+	td.facade.logger.Debugf("advertising topic %s", topicName)
+}
+
+// stopAdvertise stops advertising the given topic
+func (td *topicDiscovery) stopAdvertise(topicName string) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	if cancel, ok := td.advertised[topicName]; ok {
+		cancel()
+		delete(td.advertised, topicName)
+	}
+}
+
+// findPeers looks for peers of the given topic, and schedules connections to the peers that are not connected
+func (td *topicDiscovery) findPeers(topicName string) {
+	ctx, cancel := context.WithTimeout(td.ctx, topicDiscoveryTimeout)
+	defer cancel()
+
+	peers, err := td.disc.FindPeers(ctx, topicDiscoveryNamespacePrefix+topicName, discovery.Limit(td.limit))
+	if err != nil {
+		td.facade.logger.Debugf("could not find peers of topic %s: %v", topicName, err)
+		return
+	}
+	h := td.facade.host
+	for pi := range peers {
+		if pi.ID == h.ID() || len(pi.Addrs) == 0 || h.Network().Connectedness(pi.ID) == libp2pnetwork.Connected {
+			continue
+		}
+		td.connector.enqueue(pi, ConnectPriorityLow, PeerSourceTopicDiscovery)
+	}
+}
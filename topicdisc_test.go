@@ -0,0 +1,123 @@
+package p2pfacade
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirylm/libp2p-facade/config"
+	"github.com/amirylm/libp2p-facade/pubsub"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsublibp2p "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicDiscovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	disc := newMemDiscovery()
+	nodes := make([]Facade, 2)
+	for i := range nodes {
+		cfg := &config.Config{PubsubConfigurer: pubsub.NewNilConfigurer()}
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		cfg.TopicDiscovery = config.TopicDiscoveryConfig{Interval: 100 * time.Millisecond, MinMeshPeers: 1}
+		f, err := New(ctx, WithConfig(cfg), WithMetricsRegistry(nil))
+		require.NoError(t, err)
+		f.(*facade).disc = disc.forHost(f.Host())
+		require.NoError(t, f.Start(nil))
+		nodes[i] = f
+	}
+	defer func() {
+		for _, f := range nodes {
+			require.NoError(t, f.Close())
+		}
+	}()
+	a, b := nodes[0], nodes[1]
+
+	topicName := "test.topic.discovery"
+	require.NoError(t, a.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	require.NoError(t, b.Subscribe(topicName, func(*pubsublibp2p.Message) {}, 0))
+	require.Eventually(t, func() bool {
+		return disc.count(topicDiscoveryNamespacePrefix+topicName) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// either a or b dials the other, the source is set on the dialing side
+	discovered := func(f, other Facade) bool {
+		pi, ok := f.PeerInfo(other.Host().ID())
+		return ok && pi.Source == PeerSourceTopicDiscovery
+	}
+	require.Eventually(t, func() bool {
+		return discovered(a, b) || discovered(b, a)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(a.MeshPeers(topicName)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// advertising is stopped once the topic was left
+	require.NoError(t, a.UnSubscribe(topicName))
+	require.Eventually(t, func() bool {
+		return disc.count(topicDiscoveryNamespacePrefix+topicName) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// memDiscovery is an in-memory discovery, peers are advertised until the context of the advertisement is done
+type memDiscovery struct {
+	lock  sync.Mutex
+	peers map[string]map[peer.ID]peer.AddrInfo
+}
+
+func newMemDiscovery() *memDiscovery {
+	return &memDiscovery{peers: make(map[string]map[peer.ID]peer.AddrInfo)}
+}
+
+// forHost returns a discovery that advertises the given host
+func (md *memDiscovery) forHost(h host.Host) discovery.Discovery {
+	return &memHostDiscovery{md: md, h: h}
+}
+
+func (md *memDiscovery) count(ns string) int {
+	md.lock.Lock()
+	defer md.lock.Unlock()
+
+	return len(md.peers[ns])
+}
+
+type memHostDiscovery struct {
+	md *memDiscovery
+	h  host.Host
+}
+
+func (mhd *memHostDiscovery) Advertise(ctx context.Context, ns string, opts ...discovery.Option) (time.Duration, error) {
+	md := mhd.md
+	md.lock.Lock()
+	defer md.lock.Unlock()
+
+	if _, ok := md.peers[ns]; !ok {
+		md.peers[ns] = make(map[peer.ID]peer.AddrInfo)
+	}
+	md.peers[ns][mhd.h.ID()] = *host.InfoFromHost(mhd.h)
+	go func() {
+		<-ctx.Done()
+		md.lock.Lock()
+		defer md.lock.Unlock()
+		delete(md.peers[ns], mhd.h.ID())
+	}()
+	return time.Hour, nil
+}
+
+func (mhd *memHostDiscovery) FindPeers(ctx context.Context, ns string, opts ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	md := mhd.md
+	md.lock.Lock()
+	defer md.lock.Unlock()
+
+	out := make(chan peer.AddrInfo, len(md.peers[ns]))
+	for _, pi := range md.peers[ns] {
+		out <- pi
+	}
+	close(out)
+	return out, nil
+}